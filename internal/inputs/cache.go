@@ -0,0 +1,340 @@
+package inputs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheVersion is part of every cache key, bump it when the rendering of
+// files changes so older entries are not reused
+const cacheVersion = 1
+
+// DefaultCacheDir is the directory of the cache in the user cache dir
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "reposyn"), nil
+}
+
+// ClearCache removes the cache of every repository in dir
+func ClearCache(dir string) error {
+	return os.RemoveAll(dir)
+}
+
+// cacheEntry is the rendered block of a file with what the report needs
+// to know about it
+type cacheEntry struct {
+	// Path and Variant tell which entries a newer run replaced
+	Path    string
+	Variant string
+	// ContentKey is the key of the entry by the content of the file, kept
+	// with every hit so touched files are found again
+	ContentKey string
+	Block      string
+	Tokens     int
+	Lines      int
+	Binary     bool
+	Redactions []Redaction
+}
+
+// fileCache keeps the rendered blocks of a repository between runs. Entries
+// are found by the stamp of a file, its size and modification time or its
+// blob hash, without reading it. Files touched without a change are found
+// by the hash of their content instead.
+type fileCache struct {
+	path     string
+	mu       sync.Mutex
+	previous map[string]cacheEntry
+	current  map[string]cacheEntry
+	hits     int
+	misses   int
+}
+
+// cachePath is the path of a cache file of the repository at repoPath
+func cachePath(dir string, repoPath string, suffix string) string {
+	sum := sha256.Sum256([]byte(repoPath))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+suffix+".gob")
+}
+
+// readGob decodes the file at path into value, reporting whether it could
+func readGob(path string, value any) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return gob.NewDecoder(file).Decode(value) == nil
+}
+
+// writeGob encodes value to the file at path. It writes a temporary file
+// first so concurrent runs never read half a cache.
+func writeGob(path string, value any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "cache-*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(value); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// openCache loads the cache of the repository at repoPath from dir. A
+// missing or unreadable cache file starts an empty cache.
+func openCache(dir string, repoPath string) *fileCache {
+	cache := &fileCache{
+		path:     cachePath(dir, repoPath, ""),
+		previous: make(map[string]cacheEntry),
+		current:  make(map[string]cacheEntry),
+	}
+	if !readGob(cache.path, &cache.previous) {
+		cache.previous = make(map[string]cacheEntry)
+	}
+	return cache
+}
+
+func (c *fileCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.current[key]
+	if !ok {
+		entry, ok = c.previous[key]
+	}
+	return entry, ok
+}
+
+func (c *fileCache) put(key string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current[key] = entry
+}
+
+func (c *fileCache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// save writes the entries used in this run. Older entries are kept for the
+// files still in the repository, unless this run rendered the same variant
+// of the file, so runs with other settings keep their entries. Runs that saw
+// only some of the files pass nil paths to keep the entries of all others.
+func (c *fileCache) save(paths map[string]bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replaced := make(map[string]bool)
+	for _, entry := range c.current {
+		replaced[entry.Variant] = true
+	}
+	entries := make(map[string]cacheEntry, len(c.current))
+	for key, entry := range c.previous {
+		if (paths == nil || paths[entry.Path]) && !replaced[entry.Variant] {
+			entries[key] = entry
+		}
+	}
+	for key, entry := range c.current {
+		entries[key] = entry
+	}
+	return writeGob(c.path, entries)
+}
+
+// lineCount is the number of lines of a file with the stamp it had
+type lineCount struct {
+	Stamp string
+	Lines int
+}
+
+// lineCache keeps the line counts of the files of a repository between
+// runs for the repo statistics, so unchanged files are not read again
+type lineCache struct {
+	path     string
+	previous map[string]lineCount
+	current  map[string]lineCount
+}
+
+// openLineCache loads the line counts of the repository at repoPath from
+// dir, nil if dir is empty
+func openLineCache(dir string, repoPath string) *lineCache {
+	if dir == "" {
+		return nil
+	}
+	cache := &lineCache{
+		path:     cachePath(dir, repoPath, "-lines"),
+		previous: make(map[string]lineCount),
+		current:  make(map[string]lineCount),
+	}
+	if !readGob(cache.path, &cache.previous) {
+		cache.previous = make(map[string]lineCount)
+	}
+	return cache
+}
+
+// lines counts the lines of the file at relPath, reading it only if its
+// stamp changed. A nil cache reads every file.
+func (c *lineCache) lines(source Source, relPath string) (int, error) {
+	stamp := ""
+	if stamps, ok := source.(stampedSource); ok && c != nil {
+		stamp, _ = stamps.Stamp(relPath)
+		if count, ok := c.previous[relPath]; ok && stamp != "" && count.Stamp == stamp {
+			c.current[relPath] = count
+			return count.Lines, nil
+		}
+	}
+
+	content, err := source.ReadFile(relPath)
+	if err != nil {
+		return 0, err
+	}
+	lines := countLines(content)
+	if stamp != "" {
+		c.current[relPath] = lineCount{Stamp: stamp, Lines: lines}
+	}
+	return lines, nil
+}
+
+// save writes the line counts of the files seen in this run
+func (c *lineCache) save() error {
+	if c == nil {
+		return nil
+	}
+	return writeGob(c.path, c.current)
+}
+
+// stampedSource is a Source that tells whether a file changed without
+// reading it
+type stampedSource interface {
+	Stamp(relPath string) (string, error)
+}
+
+// cacheKey hashes the parts of a key
+func cacheKey(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// cachedRenderer renders the jobs of a run, reusing the blocks of earlier
+// runs where the file and the settings are the same
+type cachedRenderer struct {
+	cache     *fileCache
+	settings  string
+	stamps    stampedSource
+	source    Source
+	redacting *redactingSource
+	renderer  Renderer
+	tokenizer Tokenizer
+}
+
+func newCachedRenderer(config Config, cache *fileCache, base Source, source Source, renderer Renderer, tokenizer Tokenizer) cachedRenderer {
+	r := cachedRenderer{cache: cache, source: source, renderer: renderer, tokenizer: tokenizer}
+	r.stamps, _ = base.(stampedSource)
+	if redacting, ok := source.(redactingSource); ok {
+		r.redacting = &redacting
+	}
+
+	redaction := "none"
+	if config.Redactor != nil {
+		redaction = config.Redactor.fingerprint()
+	}
+	r.settings = cacheKey(fmt.Sprint(cacheVersion), config.InputDir, fmt.Sprintf("%T", renderer), tokenizer.Name(), redaction)
+	return r
+}
+
+func (r cachedRenderer) render(ctx context.Context, job FileJob) fileResult {
+	// Diffs of deleted files are not worth keeping
+	if r.cache == nil || job.diffOnly {
+		return renderJob(ctx, r.source, job, r.renderer, r.tokenizer)
+	}
+
+	variant := cacheKey(r.settings, job.relPath, fmt.Sprint(job.summarize, job.sniff), job.diff)
+	stampKey := ""
+	if r.stamps != nil {
+		if stamp, err := r.stamps.Stamp(job.relPath); err == nil {
+			stampKey = cacheKey(variant, "stamp", stamp)
+			if entry, ok := r.cache.get(stampKey); ok {
+				return r.hit(job, stampKey, entry)
+			}
+		}
+	}
+
+	content, err := r.source.ReadFile(job.relPath)
+	if err != nil {
+		r.cache.count(false)
+		return fileResult{job: job, err: &stageError{"read", err}}
+	}
+	sum := sha256.Sum256(content)
+	contentKey := cacheKey(variant, "content", hex.EncodeToString(sum[:]))
+	if entry, ok := r.cache.get(contentKey); ok {
+		return r.hit(job, stampKey, entry)
+	}
+	r.cache.count(false)
+
+	store := func(entry cacheEntry) {
+		entry.Path, entry.Variant, entry.ContentKey = job.relPath, variant, contentKey
+		r.cache.put(contentKey, entry)
+		if stampKey != "" {
+			r.cache.put(stampKey, entry)
+		}
+	}
+	record, lines, err := contentRecord(job, content)
+	if errors.Is(err, errBinary) {
+		store(cacheEntry{Binary: true})
+	}
+	if err != nil {
+		return fileResult{job: job, err: err}
+	}
+
+	// Errors are not kept, the file is tried again the next run
+	result := renderRecord(ctx, job, record, lines, r.renderer, r.tokenizer)
+	if result.err != nil {
+		return result
+	}
+	entry := cacheEntry{Block: result.block, Tokens: result.tokens, Lines: result.lines}
+	if r.redacting != nil {
+		entry.Redactions = r.redacting.redactionsOf(job.relPath)
+	}
+	store(entry)
+	return result
+}
+
+// hit turns a cache entry into the result of job and keeps it under its
+// content key and stampKey, if any
+func (r cachedRenderer) hit(job FileJob, stampKey string, entry cacheEntry) fileResult {
+	r.cache.count(true)
+	r.cache.put(entry.ContentKey, entry)
+	if stampKey != "" {
+		r.cache.put(stampKey, entry)
+	}
+	if entry.Binary {
+		return fileResult{job: job, err: errBinary}
+	}
+	if r.redacting != nil {
+		r.redacting.remember(job.relPath, entry.Redactions)
+	}
+	return fileResult{job: job, block: entry.Block, tokens: entry.Tokens, lines: entry.Lines}
+}
@@ -0,0 +1,169 @@
+package inputs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ChunkLimit bounds the size of each part of a split synopsis
+type ChunkLimit struct {
+	// Size is the most bytes, or tokens, of a part
+	Size int
+	// Tokens measures the parts in tokens of config.Tokenizer instead of bytes
+	Tokens bool
+}
+
+// measure is the size of text counted the way the limit is
+func (l ChunkLimit) measure(ctx context.Context, config Config, text string) int {
+	if !l.Tokens {
+		return len(text)
+	}
+	return CountTokens(ctx, config, text)
+}
+
+// splitBlocks groups the file blocks into parts of at most limit, counting
+// the separators between blocks. The first part carries extra overhead, it
+// is left without files if the overhead leaves no room for the first. A
+// block is never split, the index of the first one too large for a part of
+// its own is returned instead of the parts, -1 if every block fits.
+func splitBlocks(blocks []string, separator string, measure func(string) int, limit int, firstOverhead int, overhead int) ([][]string, int) {
+	var parts [][]string
+	var current []string
+	size := firstOverhead
+	for i, block := range blocks {
+		blockSize := measure(block)
+		if overhead+blockSize > limit {
+			return nil, i
+		}
+		if len(current) > 0 {
+			blockSize += measure(separator)
+		}
+		if size+blockSize > limit && (len(current) > 0 || size > overhead) {
+			parts = append(parts, current)
+			current = nil
+			size = overhead
+			blockSize = measure(block)
+		}
+		current = append(current, block)
+		size += blockSize
+	}
+	return append(parts, current), -1
+}
+
+// MergeChunks writes the synopsis split into parts of bounded size. Every
+// part starts with the header and a "part N of M" note and ends with the
+// context, the repo statistics and the tree are only part of the first.
+// open is called for each part in order and the writer closed once the part
+// is written.
+func MergeChunks(ctx context.Context, config Config, limit ChunkLimit, open func(part int, total int) (io.WriteCloser, error)) (*Report, error) {
+	if limit.Size <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive")
+	}
+	renderer := outputRenderer(config)
+
+	// The parts of the synopsis around the files, those known before the
+	// files count against the token budget
+	var header, first, footer, closing strings.Builder
+	if err := InputHeader(config, &header); err != nil {
+		return nil, err
+	}
+	// The synopsis is still useful without statistics
+	statsErr := InputRepoStats(ctx, config, &first)
+	if err := InputContext(config, &closing); err != nil {
+		return nil, err
+	}
+	if err := InputFooter(config, &closing); err != nil {
+		return nil, err
+	}
+	config.ReservedTokens = CountTokens(ctx, config, header.String()+first.String()+closing.String())
+
+	var paths, blocks []string
+	report, err := collectFiles(ctx, config, func(relPath string, block string) {
+		paths = append(paths, relPath)
+		blocks = append(blocks, block)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Tree {
+		if err := renderer.Tree(&first, report.Tree); err != nil {
+			return nil, err
+		}
+	}
+	if err := renderer.BeginFiles(&first); err != nil {
+		return nil, err
+	}
+	if err := renderer.EndFiles(&footer); err != nil {
+		return nil, err
+	}
+	if len(report.Errors) > 0 {
+		if err := renderer.Errors(&footer, report.Errors); err != nil {
+			return nil, err
+		}
+	}
+	footer.WriteString(closing.String())
+
+	var note strings.Builder
+	if err := renderer.Part(&note, 1, 1); err != nil {
+		return nil, err
+	}
+	var beginFiles strings.Builder
+	if err := renderer.BeginFiles(&beginFiles); err != nil {
+		return nil, err
+	}
+
+	// Only files are listed in the synopsis, the report has all problems
+	if statsErr != nil {
+		report.Errors = append([]RunError{{Stage: "stats", Message: statsErr.Error()}}, report.Errors...)
+	}
+
+	// Parts larger than the limit are never written, the limit is what the
+	// parts can be attached with
+	measure := func(text string) int { return limit.measure(ctx, config, text) }
+	unit := "bytes"
+	if limit.Tokens {
+		unit = "tokens"
+	}
+	overhead := measure(header.String()) + measure(note.String()) + measure(footer.String())
+	firstOverhead := overhead + measure(first.String())
+	if firstOverhead > limit.Size {
+		return nil, fmt.Errorf("the first part needs %d %s without files, more than the chunk size of %d", firstOverhead, unit, limit.Size)
+	}
+	overhead += measure(beginFiles.String())
+	parts, oversized := splitBlocks(blocks, renderer.FileSeparator(), measure, limit.Size, firstOverhead, overhead)
+	if oversized >= 0 {
+		return nil, fmt.Errorf("%s needs a part of %d %s, more than the chunk size of %d", paths[oversized], overhead+measure(blocks[oversized]), unit, limit.Size)
+	}
+
+	for i, part := range parts {
+		w, err := open(i+1, len(parts))
+		if err != nil {
+			return nil, err
+		}
+		var builder strings.Builder
+		builder.WriteString(header.String())
+		if err := renderer.Part(&builder, i+1, len(parts)); err != nil {
+			w.Close()
+			return nil, err
+		}
+		if i == 0 {
+			builder.WriteString(first.String())
+		} else {
+			builder.WriteString(beginFiles.String())
+		}
+		builder.WriteString(strings.Join(part, renderer.FileSeparator()))
+		builder.WriteString(footer.String())
+
+		if _, err := io.WriteString(w, builder.String()); err != nil {
+			w.Close()
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
@@ -1,31 +1,17 @@
 package inputs
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
+	"io"
+	"strings"
 )
 
-func InputContext(config Config) error {
-	repoName := filepath.Base(config.RepoPath)
-	instructions := fmt.Sprintf(
-		`
-
-<context>
-You are an expert software engineer who receives a summary of the repo "%s".
-Think about the contents and purpose of the repo.
-</context>
-`, repoName)
-
-	file, err := os.OpenFile(config.OutputFile, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	if _, err := file.WriteString(instructions); err != nil {
-		return err
+func InputContext(config Config, w io.Writer) error {
+	prompt := config.Prompt
+	if prompt == "" {
+		prompt = contextText(repoName(config))
+	} else if !strings.HasSuffix(prompt, "\n") {
+		prompt += "\n"
 	}
 
-	return nil
+	return outputRenderer(config).Context(w, repoName(config), prompt)
 }
@@ -0,0 +1,85 @@
+package inputs
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLength is the number of leading bytes inspected to detect binaries
+const sniffLength = 8000
+
+// errBinary marks files skipped because their content is not text
+var errBinary = errors.New("binary file")
+
+// DefaultBinaryExtensions are skipped without reading them
+func DefaultBinaryExtensions() map[string]bool {
+	return NormalizeExtensions([]string{
+		".png", ".jpg", ".jpeg", ".gif", ".bmp", ".ico", ".webp", ".tiff", ".psd",
+		".pdf", ".zip", ".gz", ".tgz", ".bz2", ".xz", ".7z", ".rar", ".tar", ".zst",
+		".exe", ".dll", ".so", ".dylib", ".a", ".o", ".obj", ".lib", ".class", ".jar",
+		".pyc", ".wasm", ".rlib", ".bin", ".dat", ".db", ".sqlite",
+		".woff", ".woff2", ".ttf", ".otf", ".eot",
+		".mp3", ".mp4", ".wav", ".ogg", ".flac", ".mov", ".avi", ".mkv", ".webm",
+		".parquet", ".feather", ".npy", ".npz", ".pkl",
+	})
+}
+
+// knownTextNames are extensionless files that are always text
+var knownTextNames = map[string]bool{
+	"makefile": true, "gnumakefile": true, "dockerfile": true, "containerfile": true,
+	"jenkinsfile": true, "vagrantfile": true, "gemfile": true, "rakefile": true,
+	"procfile": true, "brewfile": true, "justfile": true, "license": true,
+	"copying": true, "readme": true, "changelog": true, "authors": true,
+	"contributors": true, "codeowners": true, "notice": true,
+	".gitignore": true, ".gitattributes": true, ".dockerignore": true,
+	".editorconfig": true, ".env.example": true,
+}
+
+// NormalizeExtensions lower cases extensions and adds the leading dot
+func NormalizeExtensions(extensions []string) map[string]bool {
+	normalized := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.TrimSpace(ext)
+		if ext == "" {
+			continue
+		}
+		normalized["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = true
+	}
+	return normalized
+}
+
+// isKnownText reports whether a file can be included without sniffing
+func isKnownText(config Config, relPath string) bool {
+	ext := strings.ToLower(filepath.Ext(relPath))
+	return config.TextExtensions[ext] || knownTextNames[strings.ToLower(filepath.Base(relPath))]
+}
+
+// extensionAllowed applies the include and exclude lists of the config
+func extensionAllowed(config Config, relPath string) bool {
+	ext := strings.ToLower(filepath.Ext(relPath))
+	if len(config.IncludeExtensions) > 0 && !config.IncludeExtensions[ext] {
+		return false
+	}
+	return !config.ExcludeExtensions[ext]
+}
+
+// IsBinary reports whether content looks like a binary file: its first
+// bytes contain a NUL byte or are not valid UTF-8
+func IsBinary(content []byte) bool {
+	if len(content) > sniffLength {
+		content = content[:sniffLength]
+		// Do not fail on a rune cut in half at the end of the sample
+		for i := 0; i < utf8.UTFMax-1 && len(content) > 0 && !utf8.Valid(content); i++ {
+			content = content[:len(content)-1]
+		}
+	}
+
+	for _, b := range content {
+		if b == 0 {
+			return true
+		}
+	}
+	return !utf8.Valid(content)
+}
@@ -2,8 +2,11 @@ package inputs
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -22,8 +25,45 @@ func SummarizeFile(filepath string) (*FileSummary, error) {
 	}
 	defer file.Close()
 
+	return summarizeReader(file)
+}
+
+// summarizers build format specific summaries, keyed by extension
+var summarizers = map[string]func(content []byte) ([]Section, error){
+	".go":   outlineGo,
+	".csv":  summarizeTable(','),
+	".tsv":  summarizeTable('\t'),
+	".json": summarizeJSON,
+	".yaml": summarizeYAML,
+	".yml":  summarizeYAML,
+	".toml": summarizeTOML,
+}
+
+// SummarizeSections summarizes the file at relPath with the summarizer of
+// its extension. Other files, and files the summarizer can not parse, are
+// summarized by their first and last lines.
+func SummarizeSections(relPath string, content []byte) ([]Section, error) {
+	if summarizer, ok := summarizers[strings.ToLower(filepath.Ext(relPath))]; ok {
+		if sections, err := summarizer(content); err == nil {
+			return sections, nil
+		}
+	}
+
+	summary, err := SummarizeContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return summary.Sections(), nil
+}
+
+// SummarizeContent summarizes a file that has already been read
+func SummarizeContent(content []byte) (*FileSummary, error) {
+	return summarizeReader(bytes.NewReader(content))
+}
+
+func summarizeReader(reader io.Reader) (*FileSummary, error) {
 	var lines []string
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(reader)
 	totalBytes := 0
 	emptyLines := 0
 
@@ -41,14 +81,13 @@ func SummarizeFile(filepath string) (*FileSummary, error) {
 		return nil, fmt.Errorf("error reading file: %w", err)
 	}
 
+	// An empty file has an empty summary
 	totalLines := len(lines)
-	if totalLines == 0 {
-		return nil, fmt.Errorf("file is empty")
+	averageBytes := 0.0
+	if totalLines > 0 {
+		averageBytes = float64(totalBytes) / float64(totalLines)
 	}
 
-	// Calculate average bytes per line
-	averageBytes := float64(totalBytes) / float64(totalLines)
-
 	// Get first and last three lines
 	firstThree := make([]string, 0, 3)
 	lastThree := make([]string, 0, 3)
@@ -72,34 +111,17 @@ func SummarizeFile(filepath string) (*FileSummary, error) {
 	return summary, nil
 }
 
-func WriteFileSummary(filepath string, relPath string, builder *strings.Builder) error {
-	summary, err := SummarizeFile(filepath)
-	if err != nil {
-		return err
+// Sections lays out the summary for rendering
+func (s *FileSummary) Sections() []Section {
+	return []Section{
+		{Title: "First three lines", Lines: s.FirstThree},
+		{Title: "Last three lines", Lines: s.LastThree},
+		{Title: "Statistics", Fields: []Field{
+			{Name: "Total lines", Value: fmt.Sprintf("%d", s.TotalLines)},
+			{Name: "Empty lines", Value: fmt.Sprintf("%d", s.EmptyLines)},
+			{Name: "Average bytest per line", Value: fmt.Sprintf("%.2f", s.AverageBytes)},
+		}},
 	}
-
-	builder.WriteString(fmt.Sprintf("<Summary of file %v>\n", relPath))
-
-	builder.WriteString("<First three lines>\n")
-	for i, line := range summary.FirstThree {
-		fmt.Fprintf(builder, "<line index=\"%d\"><%s></line>\n", i+1, line)
-	}
-	builder.WriteString("</First three lines>\n")
-
-	builder.WriteString("<Last three lines>\n")
-	for i, line := range summary.LastThree {
-		fmt.Fprintf(builder, "<line index=\"%d\"><%s></line>\n", i+1, line)
-	}
-	builder.WriteString("</Last three lines>\n")
-
-	builder.WriteString("<Statistics>\n")
-	fmt.Fprintf(builder, "<Total lines>%d</Total lines>\n", summary.TotalLines)
-	fmt.Fprintf(builder, "<Empty lines>%d</Empty lines>\n", summary.EmptyLines)
-	fmt.Fprintf(builder, "<Average bytest per line>%.2f</Average bytest per line>\n", summary.AverageBytes)
-	builder.WriteString("</Statistics>\n")
-
-	builder.WriteString(fmt.Sprintf("</Summary of file %v>\n", relPath))
-	return nil
 }
 
 func min(a, b int) int {
@@ -0,0 +1,133 @@
+package inputs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// FileChange is a path changed between two refs with its unified diff
+type FileChange struct {
+	Path    string
+	Diff    string
+	Deleted bool
+}
+
+// ParseDiffRange splits "base..head" into its refs. With three dots,
+// "base...head", the base is the merge base of both refs, resolved later.
+func ParseDiffRange(spec string) (base string, head string, mergeBase bool, err error) {
+	if b, h, ok := strings.Cut(spec, "..."); ok {
+		base, head, mergeBase = b, h, true
+	} else if b, h, ok := strings.Cut(spec, ".."); ok {
+		base, head = b, h
+	} else {
+		return "", "", false, fmt.Errorf("invalid diff range %q, expected <base>..<head>", spec)
+	}
+
+	if base == "" {
+		return "", "", false, fmt.Errorf("invalid diff range %q, missing base", spec)
+	}
+	if head == "" {
+		head = "HEAD"
+	}
+	return base, head, mergeBase, nil
+}
+
+// CheckRevisions reports the first of revisions, if any, that does not name
+// a commit of the repository at repoPath. Empty revisions are skipped.
+func CheckRevisions(repoPath string, revisions ...string) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %w", err)
+	}
+	for _, revision := range revisions {
+		if revision == "" {
+			continue
+		}
+		if _, err := resolveCommit(repo, revision); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolveCommit(repo *git.Repository, ref string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %q: %w", ref, err)
+	}
+	return repo.CommitObject(*hash)
+}
+
+// singlePatch wraps one file patch so it can be encoded on its own
+type singlePatch struct {
+	filePatch diff.FilePatch
+}
+
+func (p singlePatch) FilePatches() []diff.FilePatch {
+	return []diff.FilePatch{p.filePatch}
+}
+
+func (p singlePatch) Message() string {
+	return ""
+}
+
+// LoadChanges computes the files changed between config.DiffBase and
+// config.DiffHead, keyed by their path relative to the repo root
+func LoadChanges(config Config) (map[string]FileChange, error) {
+	repo, err := git.PlainOpen(config.RepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+
+	base, err := resolveCommit(repo, config.DiffBase)
+	if err != nil {
+		return nil, err
+	}
+	head, err := resolveCommit(repo, config.DiffHead)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.DiffMergeBase {
+		bases, err := base.MergeBase(head)
+		if err != nil {
+			return nil, fmt.Errorf("error finding merge base: %w", err)
+		}
+		if len(bases) == 0 {
+			return nil, fmt.Errorf("no merge base between %q and %q", config.DiffBase, config.DiffHead)
+		}
+		base = bases[0]
+	}
+
+	patch, err := base.Patch(head)
+	if err != nil {
+		return nil, fmt.Errorf("error computing diff: %w", err)
+	}
+
+	changes := make(map[string]FileChange)
+	for _, filePatch := range patch.FilePatches() {
+		from, to := filePatch.Files()
+
+		var builder strings.Builder
+		encoder := diff.NewUnifiedEncoder(&builder, diff.DefaultContextLines)
+		if err := encoder.Encode(singlePatch{filePatch}); err != nil {
+			return nil, fmt.Errorf("error encoding diff: %w", err)
+		}
+
+		change := FileChange{Diff: builder.String()}
+		if to != nil {
+			change.Path = to.Path()
+		} else {
+			change.Path = from.Path()
+			change.Deleted = true
+		}
+		changes[change.Path] = change
+	}
+
+	return changes, nil
+}
@@ -1,17 +1,16 @@
 package inputs
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
-	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 // FindGitRoot searches for a .git directory starting from the current directory
@@ -41,125 +40,184 @@ func FindGitRoot(startDir string) (string, error) {
 	}
 }
 
+// ignoreMatcher matches the files left out of the synopsis. Like git, the
+// ignore files only apply to untracked files while the patterns given by the
+// user apply to all of them.
+type ignoreMatcher struct {
+	git     gitignore.Matcher
+	user    gitignore.Matcher
+	tracked map[string]bool
+}
+
+func (m ignoreMatcher) Match(path []string, isDir bool) bool {
+	if m.user.Match(path, isDir) {
+		return true
+	}
+	if m.tracked[strings.Join(path, "/")] {
+		return false
+	}
+	return m.git.Match(path, isDir)
+}
+
+// LoadGitignore builds the matcher of the files git would ignore: the global
+// excludes file, .git/info/exclude and the .gitignore files of every
+// directory, each scoped to its directory. The IgnorePatterns of the config
+// are added on top. With a ref, the .gitignore files and the tracked files
+// are taken from its tree instead of the working tree and the index.
 func LoadGitignore(config Config) (gitignore.Matcher, error) {
-	patterns := make([]gitignore.Pattern, 0)
-	repoPath := config.RepoPath
-	gitignorePath := filepath.Join(repoPath, ".gitignore")
+	patterns, err := globalIgnorePatterns()
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := os.Stat(gitignorePath); err == nil {
-		file, err := os.Open(gitignorePath)
+	var repoPatterns []gitignore.Pattern
+	var tracked map[string]bool
+	if config.Ref != "" {
+		repoPatterns, tracked, err = refIgnorePatterns(config)
 		if err != nil {
-			return nil, fmt.Errorf("error opening .gitignore: %w", err)
+			return nil, err
 		}
-		defer file.Close()
-
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line != "" && !strings.HasPrefix(line, "#") {
-				pattern := gitignore.ParsePattern(line, nil)
-				patterns = append(patterns, pattern)
-			}
+	} else {
+		repoPatterns, err = gitignore.ReadPatterns(osfs.New(config.RepoPath), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error reading .gitignore files: %w", err)
+		}
+		tracked, err = trackedFiles(config.RepoPath)
+		if err != nil {
+			return nil, err
 		}
 	}
+	patterns = append(patterns, repoPatterns...)
 
+	userPatterns := make([]gitignore.Pattern, 0)
 	for _, s := range config.IgnorePatterns {
 		pattern := gitignore.ParsePattern(s, nil)
-		patterns = append(patterns, pattern)
+		userPatterns = append(userPatterns, pattern)
 	}
 
-	return gitignore.NewMatcher(patterns), nil
+	return ignoreMatcher{
+		git:     gitignore.NewMatcher(patterns),
+		user:    gitignore.NewMatcher(userPatterns),
+		tracked: tracked,
+	}, nil
 }
 
-func InputRepoStats(config Config) error {
-	repo, err := git.PlainOpen(config.RepoPath)
+// trackedFiles lists the slash separated paths in the index of the repo
+func trackedFiles(repoPath string) (map[string]bool, error) {
+	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
-		return fmt.Errorf("error opening repository: %w", err)
+		return nil, fmt.Errorf("error opening repository: %w", err)
 	}
-
-	refs, err := repo.References()
+	index, err := repo.Storer.Index()
 	if err != nil {
-		return fmt.Errorf("error getting references: %w", err)
+		return nil, fmt.Errorf("error reading the index: %w", err)
+	}
+
+	tracked := make(map[string]bool, len(index.Entries))
+	for _, entry := range index.Entries {
+		tracked[entry.Name] = true
 	}
+	return tracked, nil
+}
 
-	var defaultRef *plumbing.Reference
-	var ErrFoundHead = errors.New("found HEAD reference")
+// refIgnorePatterns reads .git/info/exclude and the .gitignore files of the
+// tree of config.Ref, parents before their subdirectories, and lists the
+// files of the tree as tracked
+func refIgnorePatterns(config Config) ([]gitignore.Pattern, map[string]bool, error) {
+	exclude, err := os.ReadFile(filepath.Join(config.RepoPath, ".git", "info", "exclude"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	patterns := parseIgnoreFile(exclude, nil)
 
-	err = refs.ForEach(func(ref *plumbing.Reference) error {
-		if ref.Name().String() == "refs/remotes/origin/HEAD" {
-			defaultRef = ref
-			return ErrFoundHead
+	source, err := OpenSource(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ignoreFiles []string
+	tracked := make(map[string]bool)
+	err = source.Walk(func(relPath string, size int64) error {
+		if filepath.Base(relPath) == ".gitignore" {
+			ignoreFiles = append(ignoreFiles, relPath)
 		}
+		tracked[filepath.ToSlash(relPath)] = true
 		return nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if defaultRef == nil {
-		defaultRef, err = repo.Head()
+	// Patterns of subdirectories take precedence, so they come last
+	sort.SliceStable(ignoreFiles, func(i, j int) bool {
+		return strings.Count(ignoreFiles[i], string(os.PathSeparator)) < strings.Count(ignoreFiles[j], string(os.PathSeparator))
+	})
+	for _, relPath := range ignoreFiles {
+		content, err := source.ReadFile(relPath)
 		if err != nil {
-			return fmt.Errorf("error getting HEAD: %w", err)
+			return nil, nil, fmt.Errorf("error reading %s: %w", relPath, err)
+		}
+		var domain []string
+		if dir := filepath.Dir(relPath); dir != "." {
+			domain = strings.Split(dir, string(os.PathSeparator))
 		}
+		patterns = append(patterns, parseIgnoreFile(content, domain)...)
 	}
+	return patterns, tracked, nil
+}
 
-	logOptions := &git.LogOptions{
-		From:  defaultRef.Hash(),
-		Order: git.LogOrderCommitterTime,
-		All:   false,
+// parseIgnoreFile parses the patterns of an ignore file in the directory
+// given by domain, skipping comments and blank lines
+func parseIgnoreFile(content []byte, domain []string) []gitignore.Pattern {
+	var patterns []gitignore.Pattern
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, domain))
 	}
+	return patterns
+}
 
-	var builder strings.Builder
-	n_commits := 0
-
-	commitIter, err := repo.Log(logOptions)
+// globalIgnorePatterns reads the excludes file of the system and user git
+// config, falling back to git's default of $XDG_CONFIG_HOME/git/ignore
+func globalIgnorePatterns() ([]gitignore.Pattern, error) {
+	root := osfs.New("/")
+	patterns, err := gitignore.LoadSystemPatterns(root)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error reading the system excludes file: %w", err)
 	}
-	defer commitIter.Close()
-
-	builder.WriteString("<Repo statistics>\n")
-	builder.WriteString("<Most recent commits, starting at most recent>\n")
-	var ErrEnoughCommits = errors.New("reached commit limit")
-
-	err = commitIter.ForEach(func(c *object.Commit) error {
-		if n_commits >= 100 {
-			return ErrEnoughCommits
-		}
-		if n_commits < 10 {
-			builder.WriteString(fmt.Sprintf("<Commit message #%v>\n", n_commits))
-			builder.WriteString(c.Message)
-			builder.WriteString(fmt.Sprintf("</Commit message #%v>\n", n_commits))
+	global, err := gitignore.LoadGlobalPatterns(root)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the global excludes file: %w", err)
+	}
+	if global == nil {
+		global, err = defaultExcludesFile()
+		if err != nil {
+			return nil, err
 		}
-		n_commits += 1
-
-		return nil
-	})
-
-	if err != nil && err != ErrEnoughCommits {
-		return err
 	}
-	builder.WriteString("</Most recent commits, starting at most recent>\n")
+	return append(patterns, global...), nil
+}
 
-	if err != ErrEnoughCommits {
-		builder.WriteString(
-			fmt.Sprintf(
-				"<Number of commits>%v</Number of commits>\n",
-				n_commits))
-	} else {
-		builder.WriteString("<Number of commits>> 100</Number of commits>")
+func defaultExcludesFile() ([]gitignore.Pattern, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		configHome = filepath.Join(home, ".config")
 	}
-	builder.WriteString("</Repo statistics>\n")
 
-	content := builder.String()
-	// fmt.Print(content)
-	file, err := os.OpenFile(config.OutputFile, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	content, err := os.ReadFile(filepath.Join(configHome, "git", "ignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
-	defer file.Close()
-	if _, err := file.WriteString(content); err != nil {
-		return err
+	if err != nil {
+		return nil, fmt.Errorf("error reading the global excludes file: %w", err)
 	}
-
-	return nil
+	return parseIgnoreFile(content, nil), nil
 }
 
 func MakeSummaryMatcher(config Config) (gitignore.Matcher, error) {
@@ -0,0 +1,173 @@
+package inputs
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strings"
+)
+
+// outlineGo summarizes Go source by its API: the package clause, the imports
+// and the exported declarations with their doc comments, without bodies
+func outlineGo(content []byte) ([]Section, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", content, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var imports []string
+	for _, spec := range file.Imports {
+		if spec.Name != nil {
+			imports = append(imports, spec.Name.Name+" "+spec.Path.Value)
+		} else {
+			imports = append(imports, spec.Path.Value)
+		}
+	}
+
+	var declarations []string
+	add := func(doc *ast.CommentGroup, node any) error {
+		if doc != nil {
+			for _, line := range strings.Split(strings.TrimSuffix(doc.Text(), "\n"), "\n") {
+				declarations = append(declarations, strings.TrimSpace("// "+line))
+			}
+		}
+		var buffer bytes.Buffer
+		// Print like gofmt, the gaps left by dropped comments aside
+		config := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+		if err := config.Fprint(&buffer, fset, node); err != nil {
+			return err
+		}
+		for _, line := range strings.Split(buffer.String(), "\n") {
+			if strings.TrimSpace(line) != "" {
+				declarations = append(declarations, line)
+			}
+		}
+		return nil
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if !decl.Name.IsExported() || (decl.Recv != nil && !exportedType(decl.Recv.List[0].Type)) {
+				continue
+			}
+			signature := &ast.FuncDecl{Recv: decl.Recv, Name: decl.Name, Type: decl.Type}
+			if err := add(decl.Doc, signature); err != nil {
+				return nil, err
+			}
+		case *ast.GenDecl:
+			if decl.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				exported, doc := exportedSpec(decl, spec)
+				if exported == nil {
+					continue
+				}
+				if err := add(doc, &ast.GenDecl{Tok: decl.Tok, Specs: []ast.Spec{exported}}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return []Section{
+		{Title: "Package", Lines: []string{"package " + file.Name.Name}},
+		{Title: "Imports", Lines: imports},
+		{Title: "Exported declarations", Lines: declarations},
+		{Title: "Statistics", Fields: []Field{
+			{Name: "Total lines", Value: fmt.Sprintf("%d", countLines(content))},
+		}},
+	}, nil
+}
+
+// exportedType reports whether the type of a receiver or embedded field is
+// exported, pointers and type parameters aside
+func exportedType(expr ast.Expr) bool {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.SelectorExpr:
+			return e.Sel.IsExported()
+		case *ast.Ident:
+			return e.IsExported()
+		default:
+			return false
+		}
+	}
+}
+
+// exportedSpec returns the exported part of a type, const or var spec, nil
+// if nothing is exported, together with its doc comment. Struct types lose
+// their unexported fields, vars their values.
+func exportedSpec(decl *ast.GenDecl, spec ast.Spec) (ast.Spec, *ast.CommentGroup) {
+	doc := decl.Doc
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		if !spec.Name.IsExported() {
+			return nil, nil
+		}
+		if spec.Doc != nil {
+			doc = spec.Doc
+		}
+		exported := *spec
+		if structType, ok := spec.Type.(*ast.StructType); ok {
+			exported.Type = exportedFields(structType)
+		}
+		return &exported, doc
+	case *ast.ValueSpec:
+		var names []*ast.Ident
+		for _, name := range spec.Names {
+			if name.IsExported() {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, nil
+		}
+		if spec.Doc != nil {
+			doc = spec.Doc
+		}
+		exported := &ast.ValueSpec{Names: names, Type: spec.Type}
+		if decl.Tok == token.CONST && len(names) == len(spec.Names) {
+			exported.Values = spec.Values
+		}
+		return exported, doc
+	}
+	return nil, nil
+}
+
+func exportedFields(structType *ast.StructType) *ast.StructType {
+	fields := &ast.FieldList{}
+	for _, field := range structType.Fields.List {
+		if len(field.Names) == 0 {
+			// Embedded fields are exported if their type is
+			if exportedType(field.Type) {
+				fields.List = append(fields.List, field)
+			}
+			continue
+		}
+		var names []*ast.Ident
+		for _, name := range field.Names {
+			if name.IsExported() {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			exported := *field
+			exported.Names = names
+			exported.Doc, exported.Comment = nil, nil
+			fields.List = append(fields.List, &exported)
+		}
+	}
+	return &ast.StructType{Fields: fields}
+}
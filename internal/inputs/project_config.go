@@ -0,0 +1,64 @@
+package inputs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfigFiles are the names of the per repo config file, the first
+// one found at the repo root is used
+var ProjectConfigFiles = []string{".reposyn.toml", ".reposyn.yaml", ".reposyn.yml"}
+
+// ProjectConfig holds the defaults a repo commits in its .reposyn.toml
+type ProjectConfig struct {
+	Ignore      []string `toml:"ignore,omitempty" yaml:"ignore,omitempty"`
+	Summary     []string `toml:"summary,omitempty" yaml:"summary,omitempty"`
+	Extensions  []string `toml:"extensions,omitempty" yaml:"extensions,omitempty"`
+	Format      string   `toml:"format,omitempty" yaml:"format,omitempty"`
+	Tokenizer   string   `toml:"tokenizer,omitempty" yaml:"tokenizer,omitempty"`
+	MaxTokens   int      `toml:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	Order       string   `toml:"order,omitempty" yaml:"order,omitempty"`
+	Prompt      string   `toml:"prompt,omitempty" yaml:"prompt,omitempty"`
+	TrackedOnly bool     `toml:"tracked_only,omitempty" yaml:"tracked_only,omitempty"`
+}
+
+// LoadProjectConfig reads the project config file at the repo root. It
+// returns an empty config and path if the repo has none.
+func LoadProjectConfig(repoPath string) (*ProjectConfig, string, error) {
+	config := &ProjectConfig{}
+	for _, name := range ProjectConfigFiles {
+		path := filepath.Join(repoPath, name)
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading %s: %w", name, err)
+		}
+
+		if filepath.Ext(name) == ".toml" {
+			meta, err := toml.Decode(string(content), config)
+			if err != nil {
+				return nil, "", fmt.Errorf("error parsing %s: %w", name, err)
+			}
+			if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+				return nil, "", fmt.Errorf("unknown setting %q in %s", undecoded[0].String(), name)
+			}
+		} else {
+			decoder := yaml.NewDecoder(bytes.NewReader(content))
+			decoder.KnownFields(true)
+			if err := decoder.Decode(config); err != nil && err != io.EOF {
+				return nil, "", fmt.Errorf("error parsing %s: %w", name, err)
+			}
+		}
+		return config, path, nil
+	}
+
+	return config, "", nil
+}
@@ -0,0 +1,320 @@
+package inputs
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// redactRule replaces the matches of pattern by [REDACTED:kind]. If the
+// pattern has capture groups only the first group that matched is replaced.
+type redactRule struct {
+	kind    string
+	pattern *regexp.Regexp
+	// minEntropy skips matches with fewer bits per character, 0 to keep all
+	minEntropy float64
+	// files restricts the rule to the files whose base name it accepts
+	files func(base string) bool
+}
+
+// Redaction is a secret replaced in the synopsis
+type Redaction struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Kind string `json:"kind"`
+	// Diff is set if the secret was found in the diff rather than the file
+	Diff bool `json:"diff,omitempty"`
+}
+
+func (r Redaction) String() string {
+	if r.Diff {
+		return fmt.Sprintf("%s:%d (diff) %s", r.Path, r.Line, r.Kind)
+	}
+	return fmt.Sprintf("%s:%d %s", r.Path, r.Line, r.Kind)
+}
+
+// Redactor finds secrets in file contents. Lines of .env files are matched
+// with an optional leading diff marker so their diffs are redacted as well.
+type Redactor struct {
+	rules []redactRule
+}
+
+// NewRedactor returns a Redactor with the built in rules, followed by the
+// rules of the given file if path is not empty
+func NewRedactor(rulesPath string) (*Redactor, error) {
+	redactor := &Redactor{rules: defaultRedactRules()}
+	if rulesPath == "" {
+		return redactor, nil
+	}
+
+	rules, err := loadRedactRules(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	redactor.rules = append(redactor.rules, rules...)
+	return redactor, nil
+}
+
+func defaultRedactRules() []redactRule {
+	return []redactRule{
+		{kind: "private-key", pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY(?: BLOCK)?-----`)},
+		{kind: "aws-access-key", pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{kind: "aws-secret-key", pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`)},
+		{kind: "github-token", pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+		{kind: "slack-token", pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+		{kind: "slack-webhook", pattern: regexp.MustCompile(`https://hooks\.slack\.com/services/[A-Za-z0-9/]+`)},
+		{
+			kind:       "secret",
+			pattern:    regexp.MustCompile(`(?i)(?:password|passwd|pwd|secret|token|api_?key|access_?key|private_?key)["']?\s*(?::=|=>|[:=])\s*(?:"([^"\s]{8,})"|'([^'\s]{8,})')`),
+			minEntropy: 3,
+		},
+		// Unquoted values are only secrets in config files, in code they
+		// are expressions like request.headers.Authorization
+		{
+			kind:       "secret",
+			pattern:    regexp.MustCompile(`(?im)(?:password|passwd|pwd|secret|token|api_?key|access_?key|private_?key)["']?\s*[:=]\s*([^\s"'(),;]{8,})\s*$`),
+			minEntropy: 3,
+			files:      isConfigFile,
+		},
+		{
+			kind:    "env",
+			pattern: regexp.MustCompile(`(?m)^[+ -]?\s*(?:export\s+)?[A-Za-z_][A-Za-z0-9_]*\s*=\s*(\S.*?)\s*$`),
+			files:   isEnvFile,
+		},
+	}
+}
+
+// fingerprint identifies the rules, files redacted with the same rules are
+// redacted the same way
+func (r *Redactor) fingerprint() string {
+	parts := make([]string, 0, 2*len(r.rules))
+	for _, rule := range r.rules {
+		parts = append(parts, rule.kind, fmt.Sprintf("%s %g", rule.pattern, rule.minEntropy))
+	}
+	return cacheKey(parts...)
+}
+
+// isEnvFile reports whether base is a .env file, examples excluded
+func isEnvFile(base string) bool {
+	return (base == ".env" || strings.HasPrefix(base, ".env.")) && !strings.HasSuffix(base, ".example")
+}
+
+// configExtensions are the extensions of config files, whose unquoted
+// values are literal
+var configExtensions = map[string]bool{
+	".cfg": true, ".conf": true, ".ini": true, ".properties": true,
+	".toml": true, ".yaml": true, ".yml": true,
+}
+
+// isConfigFile reports whether base is a .env or config file
+func isConfigFile(base string) bool {
+	return isEnvFile(base) || configExtensions[strings.ToLower(filepath.Ext(base))]
+}
+
+// loadRedactRules reads custom rules from a TOML file of the form
+//
+//	[[rules]]
+//	kind = "internal-token"
+//	pattern = "itk_[0-9a-f]{32}"
+func loadRedactRules(path string) ([]redactRule, error) {
+	var file struct {
+		Rules []struct {
+			Kind    string `toml:"kind"`
+			Pattern string `toml:"pattern"`
+		} `toml:"rules"`
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading redact rules: %w", err)
+	}
+	meta, err := toml.Decode(string(content), &file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown setting %q in %s", undecoded[0].String(), path)
+	}
+
+	rules := make([]redactRule, 0, len(file.Rules))
+	for i, rule := range file.Rules {
+		if rule.Kind == "" || rule.Pattern == "" {
+			return nil, fmt.Errorf("redact rule #%d in %s needs a kind and a pattern", i+1, path)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of redact rule %q: %w", rule.Kind, err)
+		}
+		rules = append(rules, redactRule{kind: rule.Kind, pattern: pattern})
+	}
+	return rules, nil
+}
+
+// entropy is the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	var bits float64
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// Redact replaces the secrets in the content of the file at relPath. All
+// rules are matched against the original content, where matches overlap the
+// earliest one wins.
+func (r *Redactor) Redact(relPath string, content []byte) ([]byte, []Redaction) {
+	type span struct {
+		start, end int
+		kind       string
+	}
+	var spans []span
+	base := filepath.Base(relPath)
+	for _, rule := range r.rules {
+		if rule.files != nil && !rule.files(base) {
+			continue
+		}
+		for _, match := range rule.pattern.FindAllSubmatchIndex(content, -1) {
+			start, end := match[0], match[1]
+			for group := 1; group <= rule.pattern.NumSubexp(); group++ {
+				if match[2*group] >= 0 {
+					start, end = match[2*group], match[2*group+1]
+					break
+				}
+			}
+			if start == end {
+				continue
+			}
+			if rule.minEntropy > 0 && entropy(string(content[start:end])) < rule.minEntropy {
+				continue
+			}
+			spans = append(spans, span{start, end, rule.kind})
+		}
+	}
+	if len(spans) == 0 {
+		return content, nil
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	var redacted bytes.Buffer
+	var redactions []Redaction
+	last := 0
+	for _, s := range spans {
+		if s.start < last {
+			continue
+		}
+		redacted.Write(content[last:s.start])
+		fmt.Fprintf(&redacted, "[REDACTED:%s]", s.kind)
+		redactions = append(redactions, Redaction{
+			Path: relPath,
+			Line: bytes.Count(content[:s.start], []byte("\n")) + 1,
+			Kind: s.kind,
+		})
+		last = s.end
+	}
+	redacted.Write(content[last:])
+	return redacted.Bytes(), redactions
+}
+
+// redactingSource redacts the files read from a Source and remembers what
+// was redacted in each of them
+type redactingSource struct {
+	Source
+	redactor *Redactor
+	mu       *sync.Mutex
+	found    map[string][]Redaction
+}
+
+func newRedactingSource(source Source, redactor *Redactor) redactingSource {
+	return redactingSource{
+		Source:   source,
+		redactor: redactor,
+		mu:       &sync.Mutex{},
+		found:    make(map[string][]Redaction),
+	}
+}
+
+func (s redactingSource) ReadFile(relPath string) ([]byte, error) {
+	content, err := s.Source.ReadFile(relPath)
+	if err != nil {
+		return nil, err
+	}
+	content, redactions := s.redactor.Redact(relPath, content)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Files read twice, e.g. to summarize them, are reported once
+	s.found[relPath] = redactions
+	return content, nil
+}
+
+// redactionsOf returns what was redacted in the file at relPath
+func (s redactingSource) redactionsOf(relPath string) []Redaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.found[relPath]
+}
+
+// remember records the redactions of a file taken from the cache
+func (s redactingSource) remember(relPath string, redactions []Redaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.found[relPath] = redactions
+}
+
+// redactDiff redacts a diff, reporting the secrets with their diff line
+func (s redactingSource) redactDiff(relPath string, diff string) string {
+	redacted, redactions := s.redactor.Redact(relPath, []byte(diff))
+	if len(redactions) == 0 {
+		return diff
+	}
+	for i := range redactions {
+		redactions[i].Diff = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.found[relPath+"\x00diff"] = redactions
+	return string(redacted)
+}
+
+// redactions returns what was redacted in the given files, by path and line
+func (s redactingSource) redactions(paths map[string]bool) []Redaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []Redaction
+	for _, redactions := range s.found {
+		for _, redaction := range redactions {
+			if paths[redaction.Path] {
+				all = append(all, redaction)
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Path != all[j].Path {
+			return all[i].Path < all[j].Path
+		}
+		if all[i].Diff != all[j].Diff {
+			return !all[i].Diff
+		}
+		return all[i].Line < all[j].Line
+	})
+	return all
+}
@@ -0,0 +1,57 @@
+package inputs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// scp-like ssh syntax, e.g. git@github.com:owner/repo.git
+var scpURLPattern = regexp.MustCompile(`^[\w.-]+@[\w.-]+:`)
+
+// IsRemoteURL reports whether target is a git URL rather than a local path
+func IsRemoteURL(target string) bool {
+	for _, scheme := range []string{"https://", "http://", "ssh://", "git://", "file://"} {
+		if strings.HasPrefix(target, scheme) {
+			return true
+		}
+	}
+	return scpURLPattern.MatchString(target)
+}
+
+// RemoteRepoName derives the repo name from a URL, e.g. "ripgrep" from
+// https://github.com/BurntSushi/ripgrep.git
+func RemoteRepoName(url string) string {
+	name := strings.TrimSuffix(strings.TrimRight(url, "/"), ".git")
+	if i := strings.LastIndexAny(name, ":/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return path.Base(name)
+}
+
+// CloneRemote clones url into a new temporary directory and returns its
+// path, the caller has to remove it. The clone is shallow unless the full
+// history is needed to resolve refs or compute diffs.
+func CloneRemote(ctx context.Context, url string, fullHistory bool) (string, error) {
+	dir, err := os.MkdirTemp("", "reposyn-clone-*")
+	if err != nil {
+		return "", err
+	}
+
+	options := &git.CloneOptions{URL: url}
+	if !fullHistory {
+		options.Depth = 1
+		options.SingleBranch = true
+	}
+
+	if _, err := git.PlainCloneContext(ctx, dir, false, options); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("error cloning %s: %w", url, err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,173 @@
+package inputs
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Renderer turns the parts of a synopsis into one output format. The parts
+// are written in order: Begin, Part, RepoStats, Tree, BeginFiles, File
+// (separated by FileSeparator), EndFiles, Errors, Context and End. Part is
+// only written if the synopsis is split into several parts, Errors only if
+// files could not be processed.
+type Renderer interface {
+	Begin(w io.Writer, repoName string) error
+	Part(w io.Writer, part int, total int) error
+	RepoStats(w io.Writer, stats *RepoStats) error
+	Tree(w io.Writer, entries []TreeEntry) error
+	BeginFiles(w io.Writer) error
+	File(w io.Writer, file *FileRecord) error
+	FileSeparator() string
+	EndFiles(w io.Writer) error
+	Errors(w io.Writer, errors []RunError) error
+	Context(w io.Writer, repoName string, prompt string) error
+	End(w io.Writer) error
+}
+
+// NewRenderer returns the renderer for format, one of xml, markdown, json, jsonl
+func NewRenderer(format string) (Renderer, error) {
+	switch format {
+	case "xml", "":
+		return xmlRenderer{}, nil
+	case "markdown", "md":
+		return markdownRenderer{}, nil
+	case "json":
+		return jsonRenderer{}, nil
+	case "jsonl":
+		return jsonlRenderer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, use xml, markdown, json or jsonl", format)
+	}
+}
+
+// RepoStats holds the git history shown at the top of a synopsis, together
+// with the languages, tags and branches of the repository. Lists are cut to
+// their top entries, the Num fields count all of them.
+type RepoStats struct {
+	Commits    []string `json:"recent_commits"`
+	NumCommits int      `json:"num_commits"`
+	Truncated  bool     `json:"num_commits_truncated,omitempty"`
+	// FirstCommit is the date of the oldest commit counted, LastCommit of
+	// the newest one
+	FirstCommit     string          `json:"first_commit,omitempty"`
+	LastCommit      string          `json:"last_commit,omitempty"`
+	NumContributors int             `json:"num_contributors,omitempty"`
+	Contributors    []Contributor   `json:"top_contributors,omitempty"`
+	Languages       []LanguageStats `json:"languages,omitempty"`
+	// Churn lists the files changed by most of the last ChurnCommits commits
+	Churn        []FileChurn `json:"churn,omitempty"`
+	ChurnCommits int         `json:"churn_commits,omitempty"`
+	Tags         []Tag       `json:"tags,omitempty"`
+	NumTags      int         `json:"num_tags,omitempty"`
+	Branches     []string    `json:"branches,omitempty"`
+	NumBranches  int         `json:"num_branches,omitempty"`
+}
+
+// FileRecord is a single file of the synopsis, either with its full content
+// or with a summary. In diff mode it also carries the unified diff, deleted
+// files carry nothing else.
+type FileRecord struct {
+	Path    string    `json:"path"`
+	Content string    `json:"content,omitempty"`
+	Summary []Section `json:"summary,omitempty"`
+	Diff    string    `json:"diff,omitempty"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// Section is one titled part of a file summary, holding either numbered
+// lines or named fields
+type Section struct {
+	Title  string   `json:"title"`
+	Lines  []string `json:"lines,omitempty"`
+	Fields []Field  `json:"fields,omitempty"`
+}
+
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// contextText is the default prompt closing the synopsis
+func contextText(repoName string) string {
+	return fmt.Sprintf(
+		`You are an expert software engineer who receives a summary of the repo "%s".
+Think about the contents and purpose of the repo.
+`, repoName)
+}
+
+// repoName is the name shown in the synopsis, the clone directory of a
+// remote repo is meaningless
+func repoName(config Config) string {
+	if config.RepoName != "" {
+		return config.RepoName
+	}
+	return filepath.Base(config.RepoPath)
+}
+
+func outputRenderer(config Config) Renderer {
+	if config.Renderer == nil {
+		return xmlRenderer{}
+	}
+	return config.Renderer
+}
+
+// InputHeader writes the start of the synopsis
+func InputHeader(config Config, w io.Writer) error {
+	return outputRenderer(config).Begin(w, repoName(config))
+}
+
+// InputFooter writes the end of the synopsis
+func InputFooter(config Config, w io.Writer) error {
+	return outputRenderer(config).End(w)
+}
+
+// languageHint maps a path to the language name used for code fences
+func languageHint(path string) string {
+	names := map[string]string{
+		"makefile":      "make",
+		"gnumakefile":   "make",
+		"dockerfile":    "dockerfile",
+		"containerfile": "dockerfile",
+		"go.mod":        "go-mod",
+	}
+	if language, ok := names[strings.ToLower(filepath.Base(path))]; ok {
+		return language
+	}
+
+	languages := map[string]string{
+		".go":    "go",
+		".md":    "markdown",
+		".json":  "json",
+		".yaml":  "yaml",
+		".yml":   "yaml",
+		".xml":   "xml",
+		".html":  "html",
+		".css":   "css",
+		".js":    "javascript",
+		".jsx":   "jsx",
+		".ts":    "typescript",
+		".tsx":   "tsx",
+		".sh":    "bash",
+		".toml":  "toml",
+		".conf":  "ini",
+		".ini":   "ini",
+		".py":    "python",
+		".rs":    "rust",
+		".java":  "java",
+		".kt":    "kotlin",
+		".c":     "c",
+		".h":     "c",
+		".cpp":   "cpp",
+		".hpp":   "cpp",
+		".cs":    "csharp",
+		".rb":    "ruby",
+		".php":   "php",
+		".swift": "swift",
+		".sql":   "sql",
+		".proto": "protobuf",
+		".csv":   "csv",
+	}
+	return languages[strings.ToLower(filepath.Ext(path))]
+}
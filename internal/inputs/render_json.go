@@ -0,0 +1,146 @@
+package inputs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonRenderer writes a single JSON document. Every part after Begin starts
+// with a comma so the parts can be streamed without buffering the document.
+type jsonRenderer struct{}
+
+func writeJSON(w io.Writer, prefix string, value any, suffix string) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s%s", prefix, data, suffix)
+	return err
+}
+
+func (jsonRenderer) Begin(w io.Writer, repoName string) error {
+	return writeJSON(w, "{\"repo\":", repoName, "")
+}
+
+func (jsonRenderer) Part(w io.Writer, part int, total int) error {
+	_, err := fmt.Fprintf(w, ",\n\"part\":%d,\n\"parts\":%d", part, total)
+	return err
+}
+
+func (jsonRenderer) RepoStats(w io.Writer, stats *RepoStats) error {
+	return writeJSON(w, ",\n\"stats\":", stats, "")
+}
+
+func (jsonRenderer) Tree(w io.Writer, entries []TreeEntry) error {
+	return writeJSON(w, ",\n\"tree\":", sortedTree(entries), "")
+}
+
+func (jsonRenderer) BeginFiles(w io.Writer) error {
+	_, err := io.WriteString(w, ",\n\"files\":[\n")
+	return err
+}
+
+func (jsonRenderer) File(w io.Writer, file *FileRecord) error {
+	return writeJSON(w, "", file, "")
+}
+
+func (jsonRenderer) FileSeparator() string {
+	return ",\n"
+}
+
+func (jsonRenderer) EndFiles(w io.Writer) error {
+	_, err := io.WriteString(w, "\n]")
+	return err
+}
+
+func (jsonRenderer) Errors(w io.Writer, errors []RunError) error {
+	return writeJSON(w, ",\n\"skipped\":", errors, "")
+}
+
+func (jsonRenderer) Context(w io.Writer, repoName string, prompt string) error {
+	return writeJSON(w, ",\n\"context\":", prompt, "")
+}
+
+func (jsonRenderer) End(w io.Writer) error {
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// jsonlRenderer writes one JSON record per line, tagged with its type
+type jsonlRenderer struct{}
+
+func (jsonlRenderer) Begin(w io.Writer, repoName string) error {
+	return nil
+}
+
+func (jsonlRenderer) Part(w io.Writer, part int, total int) error {
+	record := struct {
+		Type  string `json:"type"`
+		Part  int    `json:"part"`
+		Total int    `json:"total"`
+	}{"part", part, total}
+	return writeJSON(w, "", record, "\n")
+}
+
+func (jsonlRenderer) RepoStats(w io.Writer, stats *RepoStats) error {
+	record := struct {
+		Type string `json:"type"`
+		*RepoStats
+	}{"stats", stats}
+	return writeJSON(w, "", record, "\n")
+}
+
+func (jsonlRenderer) Tree(w io.Writer, entries []TreeEntry) error {
+	record := struct {
+		Type    string      `json:"type"`
+		Entries []TreeEntry `json:"entries"`
+	}{"tree", sortedTree(entries)}
+	return writeJSON(w, "", record, "\n")
+}
+
+func (jsonlRenderer) BeginFiles(w io.Writer) error {
+	return nil
+}
+
+func (jsonlRenderer) File(w io.Writer, file *FileRecord) error {
+	record := struct {
+		Type string `json:"type"`
+		*FileRecord
+	}{"file", file}
+	return writeJSON(w, "", record, "\n")
+}
+
+func (jsonlRenderer) FileSeparator() string {
+	return ""
+}
+
+func (jsonlRenderer) EndFiles(w io.Writer) error {
+	return nil
+}
+
+func (jsonlRenderer) Errors(w io.Writer, errors []RunError) error {
+	for _, e := range errors {
+		record := struct {
+			Type string `json:"type"`
+			RunError
+		}{"skipped", e}
+		if err := writeJSON(w, "", record, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (jsonlRenderer) Context(w io.Writer, repoName string, prompt string) error {
+	record := struct {
+		Type string `json:"type"`
+		Repo string `json:"repo"`
+		Text string `json:"text"`
+	}{"context", repoName, prompt}
+	return writeJSON(w, "", record, "\n")
+}
+
+func (jsonlRenderer) End(w io.Writer) error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package inputs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// markdownRenderer writes headings and fenced code blocks with language hints
+type markdownRenderer struct{}
+
+// codeFence returns a backtick fence longer than any backtick run in content
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+func writeFenced(builder *strings.Builder, content string, language string) {
+	fence := codeFence(content)
+	fmt.Fprintf(builder, "%s%s\n", fence, language)
+	builder.WriteString(content)
+	if !strings.HasSuffix(content, "\n") {
+		builder.WriteString("\n")
+	}
+	fmt.Fprintf(builder, "%s\n\n", fence)
+}
+
+func (markdownRenderer) Begin(w io.Writer, repoName string) error {
+	_, err := fmt.Fprintf(w, "# Synopsis of %s\n\n", repoName)
+	return err
+}
+
+func (markdownRenderer) Part(w io.Writer, part int, total int) error {
+	_, err := fmt.Fprintf(w, "Part %d of %d\n\n", part, total)
+	return err
+}
+
+func (markdownRenderer) RepoStats(w io.Writer, stats *RepoStats) error {
+	var builder strings.Builder
+	builder.WriteString("## Repo statistics\n\n")
+	builder.WriteString("### Most recent commits, starting at most recent\n\n")
+	for _, message := range stats.Commits {
+		writeFenced(&builder, message, "text")
+	}
+	if !stats.Truncated {
+		fmt.Fprintf(&builder, "Number of commits: %v\n\n", stats.NumCommits)
+	} else {
+		fmt.Fprintf(&builder, "Number of commits: more than %v\n\n", stats.NumCommits)
+	}
+	for _, line := range statsLines(stats) {
+		if len(line.items) == 0 {
+			fmt.Fprintf(&builder, "%s: %s\n\n", line.title, line.value)
+			continue
+		}
+		fmt.Fprintf(&builder, "### %s\n\n", line.title)
+		for _, item := range line.items {
+			fmt.Fprintf(&builder, "- %s\n", item)
+		}
+		builder.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (markdownRenderer) Tree(w io.Writer, entries []TreeEntry) error {
+	var builder strings.Builder
+	builder.WriteString("## Directory tree\n\n")
+	writeFenced(&builder, formatTree(entries), "text")
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (markdownRenderer) BeginFiles(w io.Writer) error {
+	_, err := io.WriteString(w, "## Files\n\n")
+	return err
+}
+
+func (markdownRenderer) File(w io.Writer, file *FileRecord) error {
+	var builder strings.Builder
+	switch {
+	case file.Deleted:
+		fmt.Fprintf(&builder, "### %s (deleted)\n\n", file.Path)
+	case file.Summary == nil && (file.Content != "" || file.Diff == ""):
+		fmt.Fprintf(&builder, "### %s\n\n", file.Path)
+		writeFenced(&builder, file.Content, languageHint(file.Path))
+	case file.Summary == nil:
+		fmt.Fprintf(&builder, "### %s\n\n", file.Path)
+	default:
+		fmt.Fprintf(&builder, "### %s (summary)\n\n", file.Path)
+		for _, section := range file.Summary {
+			fmt.Fprintf(&builder, "#### %s\n\n", section.Title)
+			if len(section.Lines) > 0 {
+				writeFenced(&builder, strings.Join(section.Lines, "\n"), languageHint(file.Path))
+			}
+			for _, field := range section.Fields {
+				fmt.Fprintf(&builder, "- %s: %s\n", field.Name, field.Value)
+			}
+			if len(section.Fields) > 0 {
+				builder.WriteString("\n")
+			}
+		}
+	}
+	if file.Diff != "" {
+		builder.WriteString("#### Diff\n\n")
+		writeFenced(&builder, file.Diff, "diff")
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (markdownRenderer) FileSeparator() string {
+	return ""
+}
+
+func (markdownRenderer) EndFiles(w io.Writer) error {
+	return nil
+}
+
+func (markdownRenderer) Errors(w io.Writer, errors []RunError) error {
+	var builder strings.Builder
+	builder.WriteString("## Skipped files\n\nThese files could not be processed and are missing above.\n\n")
+	for _, e := range errors {
+		fmt.Fprintf(&builder, "- %s (%s): %s\n", e.Path, e.Stage, e.Message)
+	}
+	builder.WriteString("\n")
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (markdownRenderer) Context(w io.Writer, repoName string, prompt string) error {
+	_, err := fmt.Fprintf(w, "## Context\n\n%s", prompt)
+	return err
+}
+
+func (markdownRenderer) End(w io.Writer) error {
+	return nil
+}
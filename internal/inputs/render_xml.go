@@ -0,0 +1,125 @@
+package inputs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xmlRenderer writes the pseudo XML tags reposyn has always produced
+type xmlRenderer struct{}
+
+func (xmlRenderer) Begin(w io.Writer, repoName string) error {
+	return nil
+}
+
+func (xmlRenderer) Part(w io.Writer, part int, total int) error {
+	_, err := fmt.Fprintf(w, "<Part>%d of %d</Part>\n", part, total)
+	return err
+}
+
+func (xmlRenderer) RepoStats(w io.Writer, stats *RepoStats) error {
+	var builder strings.Builder
+	builder.WriteString("<Repo statistics>\n")
+	builder.WriteString("<Most recent commits, starting at most recent>\n")
+	for i, message := range stats.Commits {
+		fmt.Fprintf(&builder, "<Commit message #%v>\n", i)
+		builder.WriteString(message)
+		fmt.Fprintf(&builder, "</Commit message #%v>\n", i)
+	}
+	builder.WriteString("</Most recent commits, starting at most recent>\n")
+
+	if !stats.Truncated {
+		fmt.Fprintf(&builder, "<Number of commits>%v</Number of commits>\n", stats.NumCommits)
+	} else {
+		fmt.Fprintf(&builder, "<Number of commits>> %v</Number of commits>\n", stats.NumCommits)
+	}
+	for _, line := range statsLines(stats) {
+		if len(line.items) == 0 {
+			fmt.Fprintf(&builder, "<%s>%s</%s>\n", line.title, line.value, line.title)
+			continue
+		}
+		fmt.Fprintf(&builder, "<%s>\n", line.title)
+		for _, item := range line.items {
+			builder.WriteString(item + "\n")
+		}
+		fmt.Fprintf(&builder, "</%s>\n", line.title)
+	}
+	builder.WriteString("</Repo statistics>\n")
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (xmlRenderer) Tree(w io.Writer, entries []TreeEntry) error {
+	_, err := fmt.Fprintf(w, "\n<Directory tree>\n%s</Directory tree>\n", formatTree(entries))
+	return err
+}
+
+func (xmlRenderer) BeginFiles(w io.Writer) error {
+	_, err := io.WriteString(w, "\n<Files>\n")
+	return err
+}
+
+func (xmlRenderer) File(w io.Writer, file *FileRecord) error {
+	var builder strings.Builder
+	switch {
+	case file.Deleted:
+		fmt.Fprintf(&builder, "\n<Deleted file>%v</Deleted file>\n", file.Path)
+	case file.Summary == nil && (file.Content != "" || file.Diff == ""):
+		fmt.Fprintf(&builder, "\n<File = %v>\n", file.Path)
+		builder.WriteString(file.Content)
+		fmt.Fprintf(&builder, "\n</File = %v>\n", file.Path)
+	case file.Summary != nil:
+		fmt.Fprintf(&builder, "<Summary of file %v>\n", file.Path)
+		for _, section := range file.Summary {
+			fmt.Fprintf(&builder, "<%s>\n", section.Title)
+			for i, line := range section.Lines {
+				fmt.Fprintf(&builder, "<line index=\"%d\"><%s></line>\n", i+1, line)
+			}
+			for _, field := range section.Fields {
+				fmt.Fprintf(&builder, "<%s>%s</%s>\n", field.Name, field.Value, field.Name)
+			}
+			fmt.Fprintf(&builder, "</%s>\n", section.Title)
+		}
+		fmt.Fprintf(&builder, "</Summary of file %v>\n", file.Path)
+	}
+	if file.Diff != "" {
+		fmt.Fprintf(&builder, "<Diff = %v>\n", file.Path)
+		builder.WriteString(file.Diff)
+		fmt.Fprintf(&builder, "</Diff = %v>\n", file.Path)
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (xmlRenderer) FileSeparator() string {
+	return ""
+}
+
+func (xmlRenderer) EndFiles(w io.Writer) error {
+	_, err := io.WriteString(w, "\n</Files>")
+	return err
+}
+
+func (xmlRenderer) Errors(w io.Writer, errors []RunError) error {
+	var builder strings.Builder
+	builder.WriteString("\n\n<Skipped files>\n")
+	for _, e := range errors {
+		fmt.Fprintf(&builder, "<Skipped file = %v>%s: %s</Skipped file = %v>\n", e.Path, e.Stage, e.Message, e.Path)
+	}
+	builder.WriteString("</Skipped files>")
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (xmlRenderer) Context(w io.Writer, repoName string, prompt string) error {
+	_, err := fmt.Fprintf(w, "\n\n<context>\n%s</context>\n", prompt)
+	return err
+}
+
+func (xmlRenderer) End(w io.Writer) error {
+	return nil
+}
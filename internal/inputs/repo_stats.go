@@ -0,0 +1,544 @@
+package inputs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// defaultStatsCommits is the most commits counted by default
+	defaultStatsCommits = 100
+	// defaultChurnCommits is the number of recent commits looked at for the
+	// most changed files by default
+	defaultChurnCommits = 100
+	// recentCommits is the number of commit messages shown
+	recentCommits = 10
+	// topEntries is the most contributors, languages, files and tags listed
+	topEntries = 10
+	// maxBranches is the most branches listed
+	maxBranches = 20
+	// statsDateLayout is the layout of the dates in the statistics
+	statsDateLayout = "2006-01-02"
+)
+
+// Contributor is an author of commits
+type Contributor struct {
+	Name    string `json:"name"`
+	Commits int    `json:"commits"`
+}
+
+// LanguageStats is the share of a language in the files of the repository
+type LanguageStats struct {
+	Language string `json:"language"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Lines    int    `json:"lines"`
+}
+
+// FileChurn is the number of recent commits that changed a file
+type FileChurn struct {
+	Path    string `json:"path"`
+	Commits int    `json:"commits"`
+}
+
+// Tag is a tag of the repository with the date of its tag or commit
+type Tag struct {
+	Name string `json:"name"`
+	Date string `json:"date,omitempty"`
+}
+
+// languages maps extensions and file names to the language of the files
+var languages = map[string]string{
+	".go": "Go", ".py": "Python", ".js": "JavaScript", ".jsx": "JavaScript",
+	".mjs": "JavaScript", ".ts": "TypeScript", ".tsx": "TypeScript",
+	".java": "Java", ".kt": "Kotlin", ".scala": "Scala", ".rs": "Rust",
+	".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".hpp": "C++",
+	".cs": "C#", ".rb": "Ruby", ".php": "PHP", ".swift": "Swift",
+	".sh": "Shell", ".bash": "Shell", ".sql": "SQL", ".html": "HTML",
+	".css": "CSS", ".scss": "SCSS", ".md": "Markdown", ".json": "JSON",
+	".yaml": "YAML", ".yml": "YAML", ".toml": "TOML", ".xml": "XML",
+	".proto": "Protocol Buffers", ".txt": "Text", ".csv": "CSV",
+	"makefile": "Makefile", "dockerfile": "Dockerfile",
+}
+
+// languageOf is the language of a file, empty if unknown
+func languageOf(relPath string) string {
+	base := strings.ToLower(filepath.Base(relPath))
+	if language, ok := languages[base]; ok {
+		return language
+	}
+	return languages[filepath.Ext(base)]
+}
+
+// InputRepoStats writes the statistics section of the synopsis
+func InputRepoStats(ctx context.Context, config Config, w io.Writer) error {
+	stats, err := loadRepoStats(ctx, config)
+	if err != nil || stats == nil {
+		return err
+	}
+	return outputRenderer(config).RepoStats(w, stats)
+}
+
+// RenderRepoStats writes the statistics alone, as a complete document of
+// the output format rather than a section of the synopsis
+func RenderRepoStats(ctx context.Context, config Config, w io.Writer) error {
+	stats, err := loadRepoStats(ctx, config)
+	if err != nil {
+		return err
+	}
+	renderer := outputRenderer(config)
+	if _, ok := renderer.(jsonRenderer); ok {
+		return writeJSON(w, "{\"stats\":", stats, "}\n")
+	}
+	if stats == nil {
+		return nil
+	}
+	return renderer.RepoStats(w, stats)
+}
+
+// loadRepoStats gathers the statistics of the repository: its history from
+// config.Ref if set, otherwise from the default branch, the languages of its
+// files, its tags and branches. A repository without commits has none.
+func loadRepoStats(ctx context.Context, config Config) (*RepoStats, error) {
+	repo, err := git.PlainOpen(config.RepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("error getting references: %w", err)
+	}
+
+	var defaultRef *plumbing.Reference
+	var ErrFoundHead = errors.New("found HEAD reference")
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().String() == "refs/remotes/origin/HEAD" {
+			defaultRef = ref
+			return ErrFoundHead
+		}
+		return nil
+	})
+
+	if defaultRef == nil {
+		defaultRef, err = repo.Head()
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting HEAD: %w", err)
+		}
+	}
+
+	// A synopsis of a ref shows the history up to it, not what came later
+	head := defaultRef.Hash()
+	if config.Ref != "" {
+		commit, err := resolveCommit(repo, config.Ref)
+		if err != nil {
+			return nil, err
+		}
+		head = commit.Hash
+	}
+
+	stats := &RepoStats{}
+	if err := historyStats(ctx, config, repo, head, stats); err != nil {
+		return nil, err
+	}
+	if stats.Languages, err = languageStats(ctx, config); err != nil {
+		return nil, err
+	}
+	if stats.Tags, stats.NumTags, err = tagStats(repo); err != nil {
+		return nil, err
+	}
+	if stats.Branches, stats.NumBranches, err = branchStats(repo); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// historyStats walks the commits from head: the recent messages, the number
+// of commits, their dates and authors, and the files changed most often
+func historyStats(ctx context.Context, config Config, repo *git.Repository, head plumbing.Hash, stats *RepoStats) error {
+	maxCommits := config.StatsCommits
+	if maxCommits <= 0 {
+		maxCommits = defaultStatsCommits
+	}
+	churnCommits := config.ChurnCommits
+	if churnCommits <= 0 {
+		churnCommits = defaultChurnCommits
+	}
+
+	logOptions := &git.LogOptions{
+		From:  head,
+		Order: git.LogOrderCommitterTime,
+		All:   false,
+	}
+
+	// The history of a shallow clone ends at commits whose parents are
+	// missing, the default order visits commits before loading parents
+	shallow, _ := repo.Storer.Shallow()
+	if len(shallow) > 0 {
+		logOptions.Order = git.LogOrderDefault
+	}
+
+	// The walk goes on past maxCommits for the churn of more commits, only
+	// the first maxCommits are counted
+	walkCommits := max(maxCommits, churnCommits)
+	visited := 0
+	truncated := false
+	n_commits := 0
+	var first, last time.Time
+	authors := make(map[string]*Contributor)
+	var churnSource []*object.Commit
+
+	commitIter, err := repo.Log(logOptions)
+	if err != nil {
+		return err
+	}
+	defer commitIter.Close()
+
+	var ErrEnoughCommits = errors.New("reached commit limit")
+
+	err = commitIter.ForEach(func(c *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if visited >= walkCommits {
+			return ErrEnoughCommits
+		}
+		if visited < churnCommits {
+			churnSource = append(churnSource, c)
+		}
+		visited++
+		if n_commits >= maxCommits {
+			truncated = true
+			return nil
+		}
+		if n_commits < recentCommits {
+			stats.Commits = append(stats.Commits, c.Message)
+		}
+		n_commits += 1
+
+		when := c.Committer.When
+		if first.IsZero() || when.Before(first) {
+			first = when
+		}
+		if when.After(last) {
+			last = when
+		}
+		// Authors are told apart by their email, shown by their latest name
+		key := strings.ToLower(c.Author.Email)
+		if authors[key] == nil {
+			authors[key] = &Contributor{Name: c.Author.Name}
+		}
+		authors[key].Commits++
+
+		return nil
+	})
+
+	if errors.Is(err, plumbing.ErrObjectNotFound) && len(shallow) > 0 {
+		err = ErrEnoughCommits
+	}
+
+	if err != nil && err != ErrEnoughCommits {
+		return err
+	}
+	stats.NumCommits = n_commits
+	stats.Truncated = truncated || err == ErrEnoughCommits
+	if n_commits > 0 {
+		stats.FirstCommit = first.Format(statsDateLayout)
+		stats.LastCommit = last.Format(statsDateLayout)
+	}
+
+	stats.NumContributors = len(authors)
+	for _, author := range authors {
+		stats.Contributors = append(stats.Contributors, *author)
+	}
+	sort.Slice(stats.Contributors, func(i, j int) bool {
+		if stats.Contributors[i].Commits != stats.Contributors[j].Commits {
+			return stats.Contributors[i].Commits > stats.Contributors[j].Commits
+		}
+		return stats.Contributors[i].Name < stats.Contributors[j].Name
+	})
+	if len(stats.Contributors) > topEntries {
+		stats.Contributors = stats.Contributors[:topEntries]
+	}
+
+	if stats.Churn, err = churnStats(ctx, churnSource); err != nil {
+		return err
+	}
+	stats.ChurnCommits = len(churnSource)
+	return nil
+}
+
+// churnStats counts the commits changing each file, against their first
+// parent. Commits whose parent is missing, as in shallow clones, are left
+// out.
+func churnStats(ctx context.Context, commits []*object.Commit) ([]FileChurn, error) {
+	counts := make(map[string]int)
+	for _, c := range commits {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			continue
+		}
+		var parentTree *object.Tree
+		if c.NumParents() > 0 {
+			parent, err := c.Parent(0)
+			if err != nil {
+				continue
+			}
+			if parentTree, err = parent.Tree(); err != nil {
+				continue
+			}
+		}
+		changes, err := object.DiffTreeContext(ctx, parentTree, tree)
+		if err != nil {
+			continue
+		}
+		for _, change := range changes {
+			name := change.To.Name
+			if name == "" {
+				name = change.From.Name
+			}
+			counts[name]++
+		}
+	}
+
+	churn := make([]FileChurn, 0, len(counts))
+	for path, count := range counts {
+		churn = append(churn, FileChurn{Path: path, Commits: count})
+	}
+	sort.Slice(churn, func(i, j int) bool {
+		if churn[i].Commits != churn[j].Commits {
+			return churn[i].Commits > churn[j].Commits
+		}
+		return churn[i].Path < churn[j].Path
+	})
+	if len(churn) > topEntries {
+		churn = churn[:topEntries]
+	}
+	return churn, nil
+}
+
+// languageStats sums the files of known languages that are not ignored, by
+// bytes, most first
+func languageStats(ctx context.Context, config Config) ([]LanguageStats, error) {
+	matcher, err := LoadGitignore(config)
+	if err != nil {
+		return nil, err
+	}
+	source, err := OpenSource(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Line counts of unchanged files are taken from the cache
+	cache := openLineCache(config.CacheDir, config.RepoPath)
+	byLanguage := make(map[string]*LanguageStats)
+	err = source.Walk(func(relPath string, size int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		language := languageOf(relPath)
+		if language == "" || matcher.Match(strings.Split(relPath, string(os.PathSeparator)), false) {
+			return nil
+		}
+		lines, err := cache.lines(source, relPath)
+		// Unreadable files are reported with the files of the synopsis
+		if err != nil {
+			return nil
+		}
+		if byLanguage[language] == nil {
+			byLanguage[language] = &LanguageStats{Language: language}
+		}
+		byLanguage[language].Files++
+		byLanguage[language].Bytes += size
+		byLanguage[language].Lines += lines
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The cache only saves work, the statistics do not fail without it
+	cache.save()
+
+	var stats []LanguageStats
+	for _, language := range byLanguage {
+		stats = append(stats, *language)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Language < stats[j].Language
+	})
+	if len(stats) > topEntries {
+		stats = stats[:topEntries]
+	}
+	return stats, nil
+}
+
+// tagStats lists the most recent tags and counts all of them
+func tagStats(repo *git.Repository) ([]Tag, int, error) {
+	refs, err := repo.Tags()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting tags: %w", err)
+	}
+
+	type datedTag struct {
+		Tag
+		when time.Time
+	}
+	var tags []datedTag
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		tag := datedTag{Tag: Tag{Name: ref.Name().Short()}}
+		// Annotated tags have a date of their own, others that of their commit
+		if annotated, err := repo.TagObject(ref.Hash()); err == nil {
+			tag.when = annotated.Tagger.When
+		} else if commit, err := repo.CommitObject(ref.Hash()); err == nil {
+			tag.when = commit.Committer.When
+		}
+		if !tag.when.IsZero() {
+			tag.Date = tag.when.Format(statsDateLayout)
+		}
+		tags = append(tags, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if !tags[i].when.Equal(tags[j].when) {
+			return tags[i].when.After(tags[j].when)
+		}
+		return tags[i].Name > tags[j].Name
+	})
+	var recent []Tag
+	for i := 0; i < len(tags) && i < topEntries; i++ {
+		recent = append(recent, tags[i].Tag)
+	}
+	return recent, len(tags), nil
+}
+
+// branchStats lists the local and remote branches and counts all of them
+func branchStats(repo *git.Repository) ([]string, int, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting references: %w", err)
+	}
+
+	var branches []string
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name()
+		switch {
+		case name.IsBranch():
+			branches = append(branches, name.Short())
+		case name.IsRemote() && !strings.HasSuffix(name.String(), "/HEAD"):
+			branches = append(branches, name.Short())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Strings(branches)
+	total := len(branches)
+	if len(branches) > maxBranches {
+		branches = branches[:maxBranches]
+	}
+	return branches, total, nil
+}
+
+// statsLine is an entry of the statistics in the text formats, either a
+// single value or a list of items
+type statsLine struct {
+	title string
+	value string
+	items []string
+}
+
+// countOf is n with its noun, like "1 commit" or "3 commits"
+func countOf(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// statsLines lays out the statistics after the commits for the text formats
+func statsLines(stats *RepoStats) []statsLine {
+	var lines []statsLine
+	if stats.FirstCommit != "" {
+		title := "First commit"
+		if stats.Truncated {
+			title = "Oldest commit counted"
+		}
+		lines = append(lines,
+			statsLine{title: title, value: stats.FirstCommit},
+			statsLine{title: "Last commit", value: stats.LastCommit})
+	}
+	if stats.NumContributors > 0 {
+		lines = append(lines, statsLine{title: "Number of contributors", value: fmt.Sprint(stats.NumContributors)})
+		var items []string
+		for _, contributor := range stats.Contributors {
+			items = append(items, fmt.Sprintf("%s (%s)", contributor.Name, countOf(contributor.Commits, "commit")))
+		}
+		lines = append(lines, statsLine{title: "Top contributors", items: items})
+	}
+	if len(stats.Languages) > 0 {
+		var items []string
+		for _, language := range stats.Languages {
+			items = append(items, fmt.Sprintf("%s: %s, %s, %s", language.Language,
+				countOf(language.Files, "file"), formatSize(language.Bytes), countOf(language.Lines, "line")))
+		}
+		lines = append(lines, statsLine{title: "Languages", items: items})
+	}
+	if len(stats.Churn) > 0 {
+		var items []string
+		for _, file := range stats.Churn {
+			items = append(items, fmt.Sprintf("%s (%s)", file.Path, countOf(file.Commits, "commit")))
+		}
+		title := fmt.Sprintf("Most changed files in the last %s", countOf(stats.ChurnCommits, "commit"))
+		lines = append(lines, statsLine{title: title, items: items})
+	}
+	if len(stats.Tags) > 0 {
+		var items []string
+		for _, tag := range stats.Tags {
+			if tag.Date == "" {
+				items = append(items, tag.Name)
+			} else {
+				items = append(items, fmt.Sprintf("%s (%s)", tag.Name, tag.Date))
+			}
+		}
+		title := "Tags"
+		if stats.NumTags > len(stats.Tags) {
+			title = fmt.Sprintf("Tags, %d most recent of %d", len(stats.Tags), stats.NumTags)
+		}
+		lines = append(lines, statsLine{title: title, items: items})
+	}
+	if len(stats.Branches) > 0 {
+		title := "Branches"
+		if stats.NumBranches > len(stats.Branches) {
+			title = fmt.Sprintf("Branches, first %d of %d", len(stats.Branches), stats.NumBranches)
+		}
+		lines = append(lines, statsLine{title: title, items: stats.Branches})
+	}
+	return lines
+}
@@ -0,0 +1,92 @@
+package inputs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxMatchLength cuts the lines shown for search matches
+const maxMatchLength = 200
+
+// shorten cuts text to at most limit bytes followed by "...", without
+// splitting a rune
+func shorten(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	return text[:limit] + "..."
+}
+
+// SearchMatch is a line of a file matching a search
+type SearchMatch struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// Search finds the lines matching pattern in the files that would be part
+// of the synopsis, after redaction, in path order. At most limit matches
+// are returned, 0 for all of them.
+func Search(ctx context.Context, config Config, pattern *regexp.Regexp, limit int) ([]SearchMatch, error) {
+	matcher, err := LoadGitignore(config)
+	if err != nil {
+		return nil, err
+	}
+	source, err := OpenSource(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Redactor != nil {
+		source = newRedactingSource(source, config.Redactor)
+	}
+
+	var paths []string
+	err = source.Walk(func(relPath string, size int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if matcher.Match(strings.Split(relPath, string(os.PathSeparator)), false) ||
+			!extensionAllowed(config, relPath) ||
+			config.BinaryExtensions[strings.ToLower(filepath.Ext(relPath))] {
+			return nil
+		}
+		paths = append(paths, relPath)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var matches []SearchMatch
+	for _, relPath := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		content, err := source.ReadFile(relPath)
+		// Files that can not be read are left out like in the synopsis
+		if err != nil || (!isKnownText(config, relPath) && IsBinary(content)) {
+			continue
+		}
+		for i, line := range bytes.Split(content, []byte("\n")) {
+			if !pattern.Match(line) {
+				continue
+			}
+			text := shorten(string(line), maxMatchLength)
+			matches = append(matches, SearchMatch{Path: relPath, Line: i + 1, Text: text})
+			if limit > 0 && len(matches) == limit {
+				return matches, nil
+			}
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,181 @@
+package inputs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Source lists the files of a repo and reads their contents. Paths are
+// relative to the repo root and use the OS path separator.
+type Source interface {
+	Walk(fn func(relPath string, size int64) error) error
+	ReadFile(relPath string) ([]byte, error)
+}
+
+// OpenSource returns the git tree of config.Ref if set, otherwise the
+// working tree at config.InputDir, restricted to the files in the index if
+// config.TrackedOnly is set
+func OpenSource(config Config) (Source, error) {
+	if config.Ref == "" && !config.TrackedOnly {
+		return dirSource{root: config.InputDir}, nil
+	}
+
+	repo, err := git.PlainOpen(config.RepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+	if config.Ref == "" {
+		index, err := repo.Storer.Index()
+		if err != nil {
+			return nil, fmt.Errorf("error reading the index: %w", err)
+		}
+		return indexSource{dirSource: dirSource{root: config.InputDir}, index: index}, nil
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(config.Ref))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %q: %w", config.Ref, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return treeSource{tree: tree, mu: &sync.Mutex{}}, nil
+}
+
+// dirSource reads files from the filesystem
+type dirSource struct {
+	root string
+}
+
+func (s dirSource) Walk(fn func(relPath string, size int64) error) error {
+	return filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// Git's own files are never part of the synopsis
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Links may point outside of the repo, like in the other sources
+		// they are left out
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return fmt.Errorf("error getting relative path: %w", err)
+		}
+		return fn(relPath, info.Size())
+	})
+}
+
+func (s dirSource) ReadFile(relPath string) ([]byte, error) {
+	path := filepath.Join(s.root, relPath)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, fmt.Errorf("%s is a symbolic link", relPath)
+	}
+	return os.ReadFile(path)
+}
+
+// Stamp is the size and modification time of a file
+func (s dirSource) Stamp(relPath string) (string, error) {
+	info, err := os.Stat(filepath.Join(s.root, relPath))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// indexSource reads the files listed in the git index from the working
+// tree, without walking the directories
+type indexSource struct {
+	dirSource
+	index *index.Index
+}
+
+func (s indexSource) Walk(fn func(relPath string, size int64) error) error {
+	for _, entry := range s.index.Entries {
+		if entry.Mode == filemode.Submodule || entry.Mode == filemode.Symlink {
+			continue
+		}
+		relPath := filepath.FromSlash(entry.Name)
+		info, err := os.Stat(filepath.Join(s.root, relPath))
+		// Files deleted from the working tree but not from the index
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(relPath, info.Size()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeSource reads files from a git tree object, skipping symlinks. Reads
+// are serialized since go-git's object storage is not safe for concurrent use.
+type treeSource struct {
+	tree *object.Tree
+	mu   *sync.Mutex
+}
+
+func (s treeSource) Walk(fn func(relPath string, size int64) error) error {
+	return s.tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Symlink {
+			return nil
+		}
+		return fn(filepath.FromSlash(f.Name), f.Size)
+	})
+}
+
+func (s treeSource) ReadFile(relPath string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.tree.File(filepath.ToSlash(relPath))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// Stamp is the blob hash of a file
+func (s treeSource) Stamp(relPath string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.tree.File(filepath.ToSlash(relPath))
+	if err != nil {
+		return "", err
+	}
+	return f.Hash.String(), nil
+}
@@ -0,0 +1,392 @@
+package inputs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// maxShapeKeys is the most keys shown of a single object
+	maxShapeKeys = 50
+	// maxExampleLength cuts example values shown in a structure summary
+	maxExampleLength = 40
+	// maxYAMLNodes and maxYAMLDepth bound the nodes expanded for the shape
+	// of a YAML file, larger files are summarized by their lines
+	maxYAMLNodes = 100000
+	maxYAMLDepth = 100
+)
+
+// shape is the structure of a document value. Arrays hold the merged shape
+// of their elements, objects the merged shape of their keys.
+type shape struct {
+	kind    string
+	example string
+	keys    []string
+	fields  map[string]*shape
+	// count is the number of values merged into the shape, used to tell
+	// optional keys of array elements
+	count          int
+	elem           *shape
+	minLen, maxLen int
+}
+
+func scalarShape(kind string, example string) *shape {
+	return &shape{kind: kind, example: shorten(example, maxExampleLength), count: 1}
+}
+
+func objectShape() *shape {
+	return &shape{kind: "object", fields: make(map[string]*shape), count: 1}
+}
+
+func (s *shape) setField(key string, value *shape) {
+	if _, ok := s.fields[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.fields[key] = value
+}
+
+func arrayShape(items []*shape) *shape {
+	array := &shape{kind: "array", minLen: len(items), maxLen: len(items), count: 1}
+	for _, item := range items {
+		array.elem = mergeShapes(array.elem, item)
+	}
+	return array
+}
+
+// mergeShapes combines the shapes of values found at the same place
+func mergeShapes(a, b *shape) *shape {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.kind != b.kind {
+		kinds := strings.Split(a.kind, " | ")
+		if !contains(kinds, b.kind) {
+			kinds = append(kinds, b.kind)
+		}
+		return &shape{kind: strings.Join(kinds, " | "), example: a.example, count: a.count + b.count}
+	}
+
+	merged := *a
+	merged.count = a.count + b.count
+	switch a.kind {
+	case "object":
+		merged.keys = append([]string{}, a.keys...)
+		merged.fields = make(map[string]*shape, len(a.fields))
+		for key, field := range a.fields {
+			merged.fields[key] = field
+		}
+		for _, key := range b.keys {
+			merged.setField(key, mergeShapes(merged.fields[key], b.fields[key]))
+		}
+	case "array":
+		merged.minLen = min(a.minLen, b.minLen)
+		merged.maxLen = max(a.maxLen, b.maxLen)
+		merged.elem = mergeShapes(a.elem, b.elem)
+	}
+	return &merged
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// describe is the shape of a value on a single line, nested objects are
+// written by writeShape
+func (s *shape) describe() string {
+	switch s.kind {
+	case "object":
+		if len(s.keys) == 0 {
+			return "{}"
+		}
+		return "{"
+	case "array":
+		length := strconv.Itoa(s.minLen)
+		if s.maxLen != s.minLen {
+			length = fmt.Sprintf("%d-%d", s.minLen, s.maxLen)
+		}
+		if s.elem == nil {
+			return "[]"
+		}
+		return fmt.Sprintf("[%s × %s", length, s.elem.describe())
+	case "null":
+		return "null"
+	default:
+		if s.example == "" {
+			return s.kind
+		}
+		return fmt.Sprintf("%s (%s)", s.kind, s.example)
+	}
+}
+
+// writeShape appends the lines of a shape, prefix is the key and indent the
+// depth of the value
+func writeShape(lines []string, s *shape, prefix string, indent string) []string {
+	line := indent + prefix + s.describe()
+	closing := ""
+	inner := s
+	for inner.kind == "array" && inner.elem != nil {
+		closing += "]"
+		inner = inner.elem
+	}
+	if inner.kind != "object" || len(inner.keys) == 0 {
+		return append(lines, line+closing)
+	}
+
+	lines = append(lines, line)
+	for i, key := range inner.keys {
+		if i == maxShapeKeys {
+			lines = append(lines, fmt.Sprintf("%s  ... %d more keys", indent, len(inner.keys)-maxShapeKeys))
+			break
+		}
+		field := inner.fields[key]
+		name := key
+		if field.count < inner.count {
+			name += "?"
+		}
+		lines = writeShape(lines, field, name+": ", indent+"  ")
+	}
+	return append(lines, indent+"}"+closing)
+}
+
+// jsonShape reads the next value of a JSON document, keeping the key order
+func jsonShape(decoder *json.Decoder) (*shape, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '{' {
+			object := objectShape()
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := jsonShape(decoder)
+				if err != nil {
+					return nil, err
+				}
+				object.setField(fmt.Sprint(key), value)
+			}
+			_, err := decoder.Token()
+			return object, err
+		}
+		var items []*shape
+		for decoder.More() {
+			item, err := jsonShape(decoder)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err := decoder.Token()
+		return arrayShape(items), err
+	case string:
+		return scalarShape("string", strconv.Quote(token)), nil
+	case json.Number:
+		return scalarShape("number", token.String()), nil
+	case bool:
+		return scalarShape("boolean", strconv.FormatBool(token)), nil
+	default:
+		return scalarShape("null", ""), nil
+	}
+}
+
+// yamlShapes expands YAML nodes into shapes. Aliases are followed, so
+// nodes are counted to stop documents whose aliases nest into each other
+// and expand to far more nodes than the file holds.
+type yamlShapes struct {
+	// expanding are the anchored nodes the current node is part of
+	expanding map[*yaml.Node]bool
+	nodes     int
+}
+
+// shape is the shape of a YAML node, keeping the key order. An alias inside
+// its own anchor is shown as the alias.
+func (y *yamlShapes) shape(node *yaml.Node, depth int) (*shape, error) {
+	y.nodes++
+	if y.nodes > maxYAMLNodes {
+		return nil, fmt.Errorf("more than %d nodes", maxYAMLNodes)
+	}
+	if depth > maxYAMLDepth {
+		return nil, fmt.Errorf("nested deeper than %d levels", maxYAMLDepth)
+	}
+	if node.Anchor != "" {
+		if y.expanding[node] {
+			return scalarShape("alias", "*"+node.Anchor), nil
+		}
+		y.expanding[node] = true
+		defer delete(y.expanding, node)
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return scalarShape("null", ""), nil
+		}
+		return y.shape(node.Content[0], depth)
+	case yaml.AliasNode:
+		return y.shape(node.Alias, depth)
+	case yaml.MappingNode:
+		object := objectShape()
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := y.shape(node.Content[i+1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			object.setField(node.Content[i].Value, value)
+		}
+		return object, nil
+	case yaml.SequenceNode:
+		items := make([]*shape, len(node.Content))
+		for i, item := range node.Content {
+			var err error
+			if items[i], err = y.shape(item, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return arrayShape(items), nil
+	}
+
+	switch node.ShortTag() {
+	case "!!int", "!!float":
+		return scalarShape("number", node.Value), nil
+	case "!!bool":
+		return scalarShape("boolean", node.Value), nil
+	case "!!null":
+		return scalarShape("null", ""), nil
+	case "!!timestamp":
+		return scalarShape("date", node.Value), nil
+	default:
+		return scalarShape("string", strconv.Quote(node.Value)), nil
+	}
+}
+
+// valueShape is the shape of a decoded value, keys are sorted
+func valueShape(value any) *shape {
+	switch value := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		object := objectShape()
+		for _, key := range keys {
+			object.setField(key, valueShape(value[key]))
+		}
+		return object
+	case []map[string]any:
+		items := make([]*shape, len(value))
+		for i, item := range value {
+			items[i] = valueShape(item)
+		}
+		return arrayShape(items)
+	case []any:
+		items := make([]*shape, len(value))
+		for i, item := range value {
+			items[i] = valueShape(item)
+		}
+		return arrayShape(items)
+	case string:
+		return scalarShape("string", strconv.Quote(value))
+	case int64, float64:
+		return scalarShape("number", fmt.Sprint(value))
+	case bool:
+		return scalarShape("boolean", strconv.FormatBool(value))
+	case nil:
+		return scalarShape("null", "")
+	default:
+		return scalarShape("date", fmt.Sprint(value))
+	}
+}
+
+// structureSections lays out the shapes of the documents of a file
+func structureSections(documents []*shape, content []byte) []Section {
+	var lines []string
+	for i, document := range documents {
+		if i > 0 {
+			lines = append(lines, "---")
+		}
+		lines = writeShape(lines, document, "", "")
+	}
+	return []Section{
+		{Title: "Structure", Lines: lines},
+		{Title: "Statistics", Fields: []Field{
+			{Name: "Documents", Value: fmt.Sprintf("%d", len(documents))},
+			{Name: "Total lines", Value: fmt.Sprintf("%d", countLines(content))},
+		}},
+	}
+}
+
+func summarizeJSON(content []byte) ([]Section, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var documents []*shape
+	for {
+		document, err := jsonShape(decoder)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+	return structureSections(documents, content), nil
+}
+
+func summarizeYAML(content []byte) ([]Section, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	shapes := &yamlShapes{expanding: make(map[*yaml.Node]bool)}
+	var documents []*shape
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		document, err := shapes.shape(&node, 0)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+	return structureSections(documents, content), nil
+}
+
+func summarizeTOML(content []byte) ([]Section, error) {
+	var document map[string]any
+	if _, err := toml.Decode(string(content), &document); err != nil {
+		return nil, err
+	}
+	return structureSections([]*shape{valueShape(document)}, content), nil
+}
@@ -0,0 +1,186 @@
+package inputs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sampleRows is the number of data rows shown in a table summary
+	sampleRows = 5
+	// maxListedValues is the most distinct values a column may have to be
+	// listed, columns with more are only counted up to maxCountedValues
+	maxListedValues  = 10
+	maxCountedValues = 1000
+)
+
+// columnStats collects what a table summary shows about a column
+type columnStats struct {
+	name     string
+	empty    int
+	kinds    map[string]int
+	min, max float64
+	numbers  int
+	distinct map[string]int
+}
+
+func (c *columnStats) add(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		c.empty++
+		return
+	}
+	if len(c.distinct) < maxCountedValues {
+		c.distinct[value]++
+	}
+
+	kind := valueKind(value)
+	c.kinds[kind]++
+	if kind == "integer" || kind == "float" {
+		number, _ := strconv.ParseFloat(value, 64)
+		if c.numbers == 0 || number < c.min {
+			c.min = number
+		}
+		if c.numbers == 0 || number > c.max {
+			c.max = number
+		}
+		c.numbers++
+	}
+}
+
+// valueKind infers the type of a single cell
+func valueKind(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "integer"
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "float"
+	}
+	switch strings.ToLower(value) {
+	case "true", "false":
+		return "boolean"
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if _, err := time.Parse(layout, value); err == nil {
+			return "date"
+		}
+	}
+	return "string"
+}
+
+// kind is the type of the column, integers mixed with floats are floats and
+// any other mix is a string
+func (c *columnStats) kind() string {
+	switch len(c.kinds) {
+	case 0:
+		return "empty"
+	case 1:
+		for kind := range c.kinds {
+			return kind
+		}
+	case 2:
+		if c.kinds["integer"] > 0 && c.kinds["float"] > 0 {
+			return "float"
+		}
+	}
+	return "string"
+}
+
+func (c *columnStats) describe() string {
+	parts := []string{c.kind()}
+	if c.empty > 0 {
+		parts = append(parts, fmt.Sprintf("%d empty", c.empty))
+	}
+	if kind := c.kind(); kind == "integer" || kind == "float" {
+		parts = append(parts, fmt.Sprintf("min %s, max %s",
+			strconv.FormatFloat(c.min, 'g', -1, 64), strconv.FormatFloat(c.max, 'g', -1, 64)))
+	}
+
+	switch {
+	case len(c.distinct) >= maxCountedValues:
+		parts = append(parts, fmt.Sprintf("%d+ distinct values", maxCountedValues))
+	case len(c.distinct) <= maxListedValues && c.kind() != "float":
+		values := make([]string, 0, len(c.distinct))
+		for value := range c.distinct {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if c.distinct[values[i]] != c.distinct[values[j]] {
+				return c.distinct[values[i]] > c.distinct[values[j]]
+			}
+			return values[i] < values[j]
+		})
+		parts = append(parts, fmt.Sprintf("%d distinct values: %s", len(values), strings.Join(values, ", ")))
+	default:
+		parts = append(parts, fmt.Sprintf("%d distinct values", len(c.distinct)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// summarizeTable returns the summarizer of tables separated by comma
+func summarizeTable(comma rune) func(content []byte) ([]Section, error) {
+	return func(content []byte) ([]Section, error) {
+		reader := csv.NewReader(bytes.NewReader(content))
+		reader.Comma = comma
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+
+		header, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("file is empty")
+			}
+			return nil, err
+		}
+
+		columns := make([]*columnStats, len(header))
+		for i, name := range header {
+			columns[i] = &columnStats{name: name, kinds: make(map[string]int), distinct: make(map[string]int)}
+		}
+
+		var samples []string
+		rows := 0
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			rows++
+			if len(samples) < sampleRows {
+				samples = append(samples, strings.Join(record, string(comma)))
+			}
+			for i, column := range columns {
+				if i < len(record) {
+					column.add(record[i])
+				} else {
+					column.empty++
+				}
+			}
+		}
+
+		fields := make([]Field, len(columns))
+		for i, column := range columns {
+			fields[i] = Field{Name: column.name, Value: column.describe()}
+		}
+
+		return []Section{
+			{Title: "Header", Lines: []string{strings.Join(header, string(comma))}},
+			{Title: "Columns", Fields: fields},
+			{Title: "Sample rows", Lines: samples},
+			{Title: "Statistics", Fields: []Field{
+				{Name: "Rows", Value: fmt.Sprintf("%d", rows)},
+				{Name: "Columns", Value: fmt.Sprintf("%d", len(columns))},
+			}},
+		}, nil
+	}
+}
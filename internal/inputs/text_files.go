@@ -2,18 +2,21 @@ package inputs
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 )
 
 const (
-	fileBufferSize     = 256 * 1024
-	jobChannelBuffer   = 10000
-	smallFileThreshold = 32 * 1024
+	fileBufferSize   = 256 * 1024
+	jobChannelBuffer = 10000
 )
 
 func DefaultTextExtensions() map[string]bool {
@@ -35,188 +38,667 @@ func DefaultTextExtensions() map[string]bool {
 }
 
 type Config struct {
-	InputDir        string
-	OutputFile      string
-	TextExtensions  map[string]bool
-	NumWorkers      int
-	RepoPath        string
-	Clipboard       bool
-	IgnorePatterns  []string
-	SummaryPatterns []string
+	InputDir          string
+	TextExtensions    map[string]bool
+	BinaryExtensions  map[string]bool
+	IncludeExtensions map[string]bool
+	ExcludeExtensions map[string]bool
+	NumWorkers        int
+	RepoPath          string
+	RepoName          string
+	Prompt            string
+	IgnorePatterns    []string
+	SummaryPatterns   []string
+	Tokenizer         Tokenizer
+	MaxTokens         int
+	Renderer          Renderer
+	Order             string
+	Ref               string
+	DiffBase          string
+	DiffHead          string
+	DiffMergeBase     bool
+	// Tree adds the directory tree overview before the files
+	Tree bool
+	// TrackedOnly takes the files from the git index instead of walking
+	// the working tree
+	TrackedOnly bool
+	// Redactor replaces secrets in the files, nil to keep them
+	Redactor *Redactor
+	// CacheDir keeps the rendered files between runs, empty to process
+	// every file each time
+	CacheDir string
+	// Paths restricts the synopsis to these files, nil for all files
+	Paths map[string]bool
+	// StatsCommits is the most commits counted for the statistics, 0 for
+	// the default of 100
+	StatsCommits int
+	// ChurnCommits is the number of recent commits looked at for the most
+	// changed files, 0 for the default of 100
+	ChurnCommits int
+	// ReservedTokens are counted against MaxTokens before any file, for the
+	// header, statistics and context around the files. The tree is counted
+	// while the files are collected.
+	ReservedTokens int
 }
 
 type FileJob struct {
+	index     int
 	path      string
 	relPath   string
 	size      int64
 	summarize bool
+	diff      string
+	diffOnly  bool
+	deleted   bool
+	sniff     bool
 }
 
-type BatchJob struct {
-	files []FileJob
-	size  int64
+// Report describes the outcome of MergeFiles
+type Report struct {
+	Tokens *TokenReport
+	// Binary lists the files skipped because they are not text
+	Binary []string
+	// Redactions lists the secrets replaced in the synopsis
+	Redactions []Redaction
+	// Tree lists every file not ignored with what became of it
+	Tree []TreeEntry
+	// Errors lists the files left out because they could not be processed
+	Errors []RunError
+	// Cache counts the files taken from the cache and those processed
+	Cache CacheStats
 }
 
-func worker(jobs <-chan interface{}, wg *sync.WaitGroup, writer *bufio.Writer, writerMutex *sync.Mutex) {
-	defer wg.Done()
+// CacheStats counts the cache hits and misses of a run
+type CacheStats struct {
+	Enabled bool
+	Hits    int
+	Misses  int
+}
+
+// RunError is a problem found while building a synopsis. Path is empty for
+// problems not tied to a file.
+type RunError struct {
+	Path    string `json:"path,omitempty"`
+	Stage   string `json:"stage"`
+	Message string `json:"error"`
+}
+
+func (e RunError) String() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", e.Stage, e.Message)
+	}
+	return fmt.Sprintf("%s (%s): %s", e.Path, e.Stage, e.Message)
+}
+
+// stageError marks the stage of the pipeline where a file failed
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return fmt.Sprintf("error in stage %s: %v", e.stage, e.err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// runError turns the error of a file into its entry in the report
+func runError(relPath string, err error) RunError {
+	var stage *stageError
+	if errors.As(err, &stage) {
+		return RunError{Path: relPath, Stage: stage.stage, Message: stage.err.Error()}
+	}
+	return RunError{Path: relPath, Stage: "process", Message: err.Error()}
+}
+
+// fileResult is the rendered block of a job, handed back to MergeFiles
+type fileResult struct {
+	job    FileJob
+	block  string
+	tokens int
+	lines  int
+	err    error
+}
+
+// loadRecord reads the file of a job, or its summary if requested, and
+// counts its lines
+func loadRecord(source Source, job FileJob) (*FileRecord, int, error) {
+	if job.diffOnly {
+		return &FileRecord{Path: job.relPath, Diff: job.diff, Deleted: job.deleted}, 0, nil
+	}
+
+	content, err := source.ReadFile(job.relPath)
+	if err != nil {
+		return nil, 0, &stageError{"read", err}
+	}
+	return contentRecord(job, content)
+}
 
-	fileBuffer := make([]byte, fileBufferSize)
-	stringBuffer := strings.Builder{}
-	stringBuffer.Grow(fileBufferSize * 2) // Pre-allocate space
+// contentRecord is the record of a job for the content of its file
+func contentRecord(job FileJob, content []byte) (*FileRecord, int, error) {
+	record := &FileRecord{Path: job.relPath, Diff: job.diff, Deleted: job.deleted}
+	if job.sniff && IsBinary(content) {
+		return nil, 0, errBinary
+	}
+	lines := countLines(content)
 
-	processFile := func(job FileJob) error {
-		file, err := os.Open(job.path)
+	if job.summarize {
+		summary, err := SummarizeSections(job.relPath, content)
 		if err != nil {
-			return err
+			return nil, 0, &stageError{"summarize", err}
 		}
-		defer file.Close()
-		if !job.summarize {
-			// Just write whole file to buffer
-			stringBuffer.WriteString(fmt.Sprintf("\n<File = %v>\n", job.relPath))
-			for {
-				n, err := file.Read(fileBuffer)
-				if n > 0 {
-					stringBuffer.Write(fileBuffer[:n])
-				}
-				if err == io.EOF {
-					break
-				}
-				if err != nil {
-					return err
-				}
-			}
-			stringBuffer.WriteString(fmt.Sprintf("\n</File = %v>\n", job.relPath))
-		} else {
-			WriteFileSummary(job.path, job.relPath, &stringBuffer)
-		}
-		return nil
+		record.Summary = summary
+		return record, lines, nil
+	}
+
+	record.Content = string(content)
+	return record, lines, nil
+}
+
+// renderJob loads and renders a job and estimates the tokens of the block
+func renderJob(ctx context.Context, source Source, job FileJob, renderer Renderer, tokenizer Tokenizer) fileResult {
+	record, lines, err := loadRecord(source, job)
+	if err != nil {
+		return fileResult{job: job, lines: lines, err: err}
+	}
+	return renderRecord(ctx, job, record, lines, renderer, tokenizer)
+}
+
+// renderRecord renders the record of a job and estimates its tokens. The
+// result of a job cancelled while counting carries the error of ctx.
+func renderRecord(ctx context.Context, job FileJob, record *FileRecord, lines int, renderer Renderer, tokenizer Tokenizer) fileResult {
+	result := fileResult{job: job, lines: lines}
+	var builder strings.Builder
+	if err := renderer.File(&builder, record); err != nil {
+		result.err = &stageError{"render", err}
+		return result
+	}
+	result.block = builder.String()
+	result.tokens = tokenizer.Count(ctx, result.block)
+	if err := ctx.Err(); err != nil {
+		return fileResult{job: job, err: err}
 	}
+	return result
+}
+
+func worker(ctx context.Context, render func(context.Context, FileJob) fileResult, jobs <-chan FileJob, results chan<- fileResult, wg *sync.WaitGroup) {
+	defer wg.Done()
 
+	// Once cancelled the remaining jobs are dropped, nobody waits for them
 	for job := range jobs {
-		switch j := job.(type) {
-		case FileJob:
-			if err := processFile(j); err != nil {
-				fmt.Fprintf(os.Stderr, "Error processing file %s: %v\n", j.path, err)
-			}
-		case BatchJob:
-			for _, f := range j.files {
-				if err := processFile(f); err != nil {
-					fmt.Fprintf(os.Stderr, "Error processing file %s: %v\n", f.path, err)
-				}
+		if ctx.Err() != nil {
+			continue
+		}
+		select {
+		case results <- render(ctx, job):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// countTreeTokens is the token estimate of the rendered tree of entries
+func countTreeTokens(ctx context.Context, renderer Renderer, tokenizer Tokenizer, entries []TreeEntry) int {
+	var builder strings.Builder
+	if err := renderer.Tree(&builder, entries); err != nil {
+		return 0
+	}
+	return tokenizer.Count(ctx, builder.String())
+}
+
+// CheckOrder reports an order the files can not be sorted by
+func CheckOrder(order string) error {
+	return orderJobs(nil, order)
+}
+
+// orderJobs sorts the jobs by the given order and numbers them
+func orderJobs(jobs []FileJob, order string) error {
+	switch order {
+	case "path", "":
+		sort.SliceStable(jobs, func(i, j int) bool {
+			return jobs[i].relPath < jobs[j].relPath
+		})
+	case "important":
+		sort.SliceStable(jobs, func(i, j int) bool {
+			ri, rj := importance(jobs[i].relPath), importance(jobs[j].relPath)
+			if ri != rj {
+				return ri < rj
 			}
+			return jobs[i].relPath < jobs[j].relPath
+		})
+	default:
+		return fmt.Errorf("unknown order %q, use path or important", order)
+	}
+
+	for i := range jobs {
+		jobs[i].index = i
+	}
+	return nil
+}
+
+// importance ranks a path for the "important" order, lower ranks come first:
+// readmes, build manifests, entry points, top level files, other files, tests
+func importance(relPath string) int {
+	base := strings.ToLower(filepath.Base(relPath))
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	depth := strings.Count(relPath, string(os.PathSeparator))
+
+	manifests := map[string]bool{
+		"go.mod": true, "package.json": true, "cargo.toml": true,
+		"pyproject.toml": true, "setup.py": true, "pom.xml": true,
+		"build.gradle": true, "makefile": true, "dockerfile": true,
+	}
+
+	switch {
+	case stem == "readme":
+		if depth == 0 {
+			return 0
 		}
+		return 3
+	case manifests[base]:
+		return 1
+	case stem == "main" || stem == "index" || stem == "app" || stem == "lib":
+		return 2
+	case strings.HasSuffix(stem, "_test") || strings.HasPrefix(stem, "test_") ||
+		strings.Contains(relPath, "test"+string(os.PathSeparator)) ||
+		strings.Contains(relPath, "tests"+string(os.PathSeparator)):
+		return 5
+	case depth == 0:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// MergeFiles writes the files of the synopsis to w, preceded by the tree if
+// config.Tree is set
+func MergeFiles(ctx context.Context, config Config, w io.Writer) (*Report, error) {
+	writer := bufio.NewWriterSize(w, fileBufferSize*2)
+	renderer := outputRenderer(config)
+
+	// The tree needs the outcome of every file, so the files are held back
+	// until all of them are processed
+	files := io.Writer(writer)
+	var held bytes.Buffer
+	if config.Tree {
+		files = &held
+	} else if err := renderer.BeginFiles(writer); err != nil {
+		return nil, err
+	}
 
-		// Flush buffer if full
-		if stringBuffer.Len() >= fileBufferSize {
-			writerMutex.Lock()
-			writer.WriteString(stringBuffer.String())
-			writerMutex.Unlock()
-			stringBuffer.Reset()
+	written := 0
+	report, err := collectFiles(ctx, config, func(relPath string, block string) {
+		if written > 0 {
+			io.WriteString(files, renderer.FileSeparator())
 		}
+		io.WriteString(files, block)
+		written++
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Flush remaining content
-	if stringBuffer.Len() > 0 {
-		writerMutex.Lock()
-		writer.WriteString(stringBuffer.String())
-		writerMutex.Unlock()
+	if config.Tree {
+		if err := renderer.Tree(writer, report.Tree); err != nil {
+			return nil, err
+		}
+		if err := renderer.BeginFiles(writer); err != nil {
+			return nil, err
+		}
+		if _, err := held.WriteTo(writer); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := renderer.EndFiles(writer); err != nil {
+		return nil, err
+	}
+	if len(report.Errors) > 0 {
+		if err := renderer.Errors(writer, report.Errors); err != nil {
+			return nil, err
+		}
 	}
+	return report, writer.Flush()
 }
 
-func MergeFiles(config Config) error {
-	outFile, err := os.OpenFile(config.OutputFile, os.O_APPEND|os.O_WRONLY, 0644)
+// ErrNotIncluded is returned for files that are not part of the synopsis
+var ErrNotIncluded = errors.New("file is not part of the synopsis")
+
+// RenderFile returns the block of the single file at relPath as MergeFiles
+// would write it, without a token budget
+func RenderFile(ctx context.Context, config Config, relPath string) (string, error) {
+	relPath = filepath.Clean(filepath.FromSlash(relPath))
+	config.Paths = map[string]bool{relPath: true}
+	config.MaxTokens = 0
+
+	var block string
+	written := false
+	report, err := collectFiles(ctx, config, func(_ string, b string) {
+		block, written = b, true
+	})
 	if err != nil {
-		return fmt.Errorf("error opening output file: %w", err)
+		return "", err
+	}
+	if len(report.Errors) > 0 {
+		return "", errors.New(report.Errors[0].String())
 	}
-	defer outFile.Close()
+	if !written {
+		if len(report.Tree) > 0 {
+			return "", fmt.Errorf("%w: %s is %s", ErrNotIncluded, relPath, report.Tree[0].Status)
+		}
+		return "", fmt.Errorf("%w: %s does not exist or is ignored", ErrNotIncluded, relPath)
+	}
+	return block, nil
+}
 
-	writer := bufio.NewWriterSize(outFile, fileBufferSize*2)
-	defer writer.Flush()
+// ListTree returns the entries of the directory tree overview, every file
+// not ignored with what became of it
+func ListTree(ctx context.Context, config Config) ([]TreeEntry, error) {
+	report, err := collectFiles(ctx, config, func(string, string) {})
+	if err != nil {
+		return nil, err
+	}
+	return report.Tree, nil
+}
 
+// collectFiles selects, reads and renders the files of the synopsis and
+// hands their blocks to sink in order
+func collectFiles(ctx context.Context, config Config, sink func(relPath string, block string)) (*Report, error) {
 	matcher, err := LoadGitignore(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	summaryMatcher, err := MakeSummaryMatcher(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	jobs := make(chan interface{}, jobChannelBuffer)
-
-	var writerMutex sync.Mutex
-	var wg sync.WaitGroup
-
-	// Start worker fill
-	for i := 0; i < config.NumWorkers; i++ {
-		wg.Add(1)
-		go worker(jobs, &wg, writer, &writerMutex)
+	tokenizer := config.Tokenizer
+	if tokenizer == nil {
+		tokenizer = charsTokenizer{}
+	}
+	report := &TokenReport{Tokenizer: tokenizer.Name(), Budget: config.MaxTokens}
+	report.addOverhead(config.ReservedTokens)
+	var binary []string
+	renderer := outputRenderer(config)
+
+	// In diff mode only changed files are included, together with their diff
+	var changes map[string]FileChange
+	if config.DiffBase != "" {
+		changes, err = LoadChanges(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[string]bool)
+
+	// Every file not ignored shows up in the tree, with what became of it
+	var tree []TreeEntry
+	treeIndex := make(map[string]int)
+	setStatus := func(relPath string, status string, lines int) {
+		if i, ok := treeIndex[relPath]; ok {
+			tree[i].Status = status
+			tree[i].Lines = lines
+		}
 	}
 
-	// Collect small files for batching
-	var currentBatch []FileJob
-	var currentBatchSize int64
-
-	writer.WriteString("\n<Files>\n")
+	base, err := OpenSource(config)
+	if err != nil {
+		return nil, err
+	}
+	source := base
+	redactDiff := func(relPath string, diff string) string { return diff }
+	if config.Redactor != nil {
+		redacting := newRedactingSource(source, config.Redactor)
+		source = redacting
+		redactDiff = redacting.redactDiff
+	}
 
-	// Walk directory and send jobs
-	err = filepath.Walk(config.InputDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+	// Walk the source and collect jobs
+	var fileJobs []FileJob
+	err = source.Walk(func(relPath string, size int64) error {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-
-		relPath, err := filepath.Rel(config.InputDir, path)
-		if err != nil {
-			return fmt.Errorf("error getting relative path: %w", err)
+		if config.Paths != nil && !config.Paths[relPath] {
+			return nil
 		}
 
 		if matcher.Match(strings.Split(relPath, string(os.PathSeparator)), false) {
 			return nil
 		}
+		treeIndex[relPath] = len(tree)
+		tree = append(tree, TreeEntry{Path: relPath, Size: size})
 
-		ext := strings.ToLower(filepath.Ext(path))
-		if !config.TextExtensions[ext] {
+		if !extensionAllowed(config, relPath) {
+			setStatus(relPath, statusExcluded, 0)
+			return nil
+		}
+		if config.BinaryExtensions[strings.ToLower(filepath.Ext(relPath))] {
+			setStatus(relPath, statusBinary, 0)
+			binary = append(binary, relPath)
 			return nil
 		}
 
 		shouldBeSummarized := summaryMatcher.Match(strings.Split(relPath, string(os.PathSeparator)), false)
 
 		fileJob := FileJob{
-			path:      path,
+			path:      filepath.Join(config.InputDir, relPath),
 			relPath:   relPath,
-			size:      info.Size(),
+			size:      size,
 			summarize: shouldBeSummarized,
+			sniff:     !isKnownText(config, relPath),
 		}
 
-		// Batch small files together
-		if info.Size() <= smallFileThreshold {
-			currentBatch = append(currentBatch, fileJob)
-			currentBatchSize += info.Size()
-
-			// Send batch if it's full
-			if currentBatchSize >= fileBufferSize {
-				jobs <- BatchJob{files: currentBatch, size: currentBatchSize}
-				currentBatch = make([]FileJob, 0, 100)
-				currentBatchSize = 0
+		if changes != nil {
+			change, ok := changes[filepath.ToSlash(relPath)]
+			if !ok {
+				setStatus(relPath, statusUnchanged, 0)
+				return nil
 			}
-		} else {
-			// Send large files individually
-			jobs <- fileJob
+			seen[change.Path] = true
+			fileJob.diff = redactDiff(relPath, change.Diff)
 		}
 
+		fileJobs = append(fileJobs, fileJob)
+
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Changed files missing from the working tree, e.g. deleted ones, are
+	// represented by their diff alone
+	for _, change := range changes {
+		if seen[change.Path] {
+			continue
+		}
+		if config.Paths != nil && !config.Paths[filepath.FromSlash(change.Path)] {
+			continue
+		}
+		parts := strings.Split(change.Path, "/")
+		ext := strings.ToLower(filepath.Ext(change.Path))
+		if matcher.Match(parts, false) || !extensionAllowed(config, change.Path) || config.BinaryExtensions[ext] {
+			continue
+		}
+		treeIndex[filepath.FromSlash(change.Path)] = len(tree)
+		tree = append(tree, TreeEntry{Path: filepath.FromSlash(change.Path), Status: statusDeleted})
+		fileJobs = append(fileJobs, FileJob{
+			path:     filepath.Join(config.InputDir, filepath.FromSlash(change.Path)),
+			relPath:  filepath.FromSlash(change.Path),
+			diff:     redactDiff(filepath.FromSlash(change.Path), change.Diff),
+			diffOnly: true,
+			deleted:  change.Deleted,
+		})
+	}
+
+	if err := orderJobs(fileJobs, config.Order); err != nil {
+		return nil, err
+	}
+
+	// The statuses and lines of the tree are only known once the files are
+	// done, until then it is charged with the longest status and at most a
+	// line per byte for each file
+	treeTokens := 0
+	if config.Tree {
+		estimate := make([]TreeEntry, len(tree))
+		for i, entry := range tree {
+			estimate[i] = entry
+			if estimate[i].Status == "" {
+				estimate[i].Status = statusBudget
+				estimate[i].Lines = int(entry.Size)
+			}
+		}
+		treeTokens = countTreeTokens(ctx, renderer, tokenizer, estimate)
+		report.addOverhead(treeTokens)
+	}
+
+	// Workers read and render in parallel, the results are reassembled
+	// in job order below
+	jobs := make(chan FileJob, jobChannelBuffer)
+	results := make(chan fileResult, jobChannelBuffer)
+
+	// Files unchanged since an earlier run are taken from the cache
+	var cache *fileCache
+	if config.CacheDir != "" {
+		cache = openCache(config.CacheDir, config.RepoPath)
+	}
+	render := newCachedRenderer(config, cache, base, source, renderer, tokenizer).render
+
+	var wg sync.WaitGroup
+
+	// Start worker fill
+	for i := 0; i < config.NumWorkers; i++ {
+		wg.Add(1)
+		go worker(ctx, render, jobs, results, &wg)
+	}
+
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+			close(results)
+		}()
+		for _, job := range fileJobs {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	writtenPaths := make(map[string]bool)
+	var runErrors []RunError
+	emit := func(result fileResult) {
+		if errors.Is(result.err, errBinary) {
+			setStatus(result.job.relPath, statusBinary, 0)
+			binary = append(binary, result.job.relPath)
+			return
+		}
+		if result.err != nil {
+			setStatus(result.job.relPath, statusError, 0)
+			runErrors = append(runErrors, runError(result.job.relPath, result.err))
+			return
+		}
+
+		status := "included"
+		if result.job.summarize {
+			status = "summarized"
+		}
+
+		// Keep within the token budget, downgrading to a summary or
+		// dropping the file once the full content no longer fits
+		if config.MaxTokens > 0 && report.Total+result.tokens > config.MaxTokens {
+			if result.job.summarize {
+				report.add(result.job.relPath, result.tokens, "skipped")
+				setStatus(result.job.relPath, statusBudget, result.lines)
+				return
+			}
+			summaryJob := result.job
+			summaryJob.summarize = true
+			summary := render(ctx, summaryJob)
+			if summary.err != nil || report.Total+summary.tokens > config.MaxTokens {
+				report.add(result.job.relPath, result.tokens, "skipped")
+				setStatus(result.job.relPath, statusBudget, result.lines)
+				return
+			}
+			result = summary
+			status = "summarized"
+		}
+		report.add(result.job.relPath, result.tokens, status)
+		if !result.job.deleted {
+			setStatus(result.job.relPath, status, result.lines)
+		}
 
-	// Send remaining batch if any
-	if len(currentBatch) > 0 {
-		jobs <- BatchJob{files: currentBatch, size: currentBatchSize}
+		sink(result.job.relPath, result.block)
+		writtenPaths[result.job.relPath] = true
 	}
 
-	close(jobs)
-	wg.Wait()
+	// A cancelled run returns at once, the workers finish their current
+	// job in the background
+	pending := make(map[int]fileResult)
+	next := 0
+	for {
+		var result fileResult
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result, ok = <-results:
+		}
+		if !ok {
+			break
+		}
+		pending[result.job.index] = result
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			emit(ready)
+			next++
+		}
+	}
 
-	writer.WriteString("\n</Files>")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	return err
+	if config.Tree {
+		report.addOverhead(countTreeTokens(ctx, renderer, tokenizer, tree) - treeTokens)
+	}
+
+	var cacheStats CacheStats
+	if cache != nil {
+		// Files left out of a run restricted to some paths may still exist
+		var paths map[string]bool
+		if config.Paths == nil {
+			paths = make(map[string]bool, len(tree))
+			for _, entry := range tree {
+				paths[entry.Path] = true
+			}
+		}
+		// The cache only saves work, a run does not fail without it
+		cache.save(paths)
+		cacheStats = CacheStats{Enabled: true, Hits: cache.hits, Misses: cache.misses}
+	}
+
+	sort.Strings(binary)
+	var redactions []Redaction
+	if redacting, ok := source.(redactingSource); ok {
+		redactions = redacting.redactions(writtenPaths)
+	}
+	return &Report{
+		Tokens:     report,
+		Binary:     binary,
+		Redactions: redactions,
+		Tree:       tree,
+		Errors:     runErrors,
+		Cache:      cacheStats,
+	}, nil
 }
@@ -0,0 +1,277 @@
+package inputs
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//go:embed bpe_merges.txt
+var bpeMerges string
+
+// Pre-tokenization pattern in the spirit of GPT-2, without lookahead since
+// Go's regexp does not support it.
+var preTokenPattern = regexp.MustCompile(`'s|'t|'re|'ve|'m|'ll|'d| ?\pL+| ?\pN+| ?[^\s\pL\pN]+|\s+`)
+
+// Tokenizer estimates how many tokens a model needs for a piece of text.
+// Count stops early, with a partial count, once ctx is done.
+type Tokenizer interface {
+	Name() string
+	Count(ctx context.Context, text string) int
+}
+
+// NewTokenizer returns the tokenizer registered under name, "bpe" or "chars"
+func NewTokenizer(name string) (Tokenizer, error) {
+	switch name {
+	case "bpe", "":
+		return newBPETokenizer(), nil
+	case "chars":
+		return charsTokenizer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown tokenizer %q, use bpe or chars", name)
+	}
+}
+
+// charsTokenizer is the cheap rule of thumb of one token per four characters
+type charsTokenizer struct{}
+
+func (charsTokenizer) Name() string { return "chars" }
+
+func (charsTokenizer) Count(ctx context.Context, text string) int {
+	return (len(text) + 3) / 4
+}
+
+// bpeTokenizer applies byte pair merges learned offline (see
+// scripts/trainbpe) to every pre-token and counts the resulting symbols.
+type bpeTokenizer struct {
+	ranks    map[[2]string]int
+	byteRune [256]string
+
+	mu    sync.Mutex
+	cache map[string]int
+}
+
+// The counts of pieces are cached as the same words come up again and
+// again. The cache is emptied once full, it lives as long as the process.
+const (
+	maxCachedPiece  = 64
+	maxCachedPieces = 1 << 16
+)
+
+// cancelCheckInterval is how many pieces are counted between two looks at
+// the context
+const cancelCheckInterval = 1024
+
+var (
+	defaultBPE     *bpeTokenizer
+	defaultBPEOnce sync.Once
+)
+
+func newBPETokenizer() *bpeTokenizer {
+	defaultBPEOnce.Do(func() {
+		t := &bpeTokenizer{
+			ranks: make(map[[2]string]int),
+			cache: make(map[string]int),
+		}
+		for b, r := range bytesToUnicode() {
+			t.byteRune[b] = string(r)
+		}
+		scanner := bufio.NewScanner(strings.NewReader(bpeMerges))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			left, right, ok := strings.Cut(line, " ")
+			if !ok {
+				continue
+			}
+			t.ranks[[2]string{left, right}] = len(t.ranks)
+		}
+		defaultBPE = t
+	})
+	return defaultBPE
+}
+
+func (t *bpeTokenizer) Name() string { return "bpe" }
+
+func (t *bpeTokenizer) Count(ctx context.Context, text string) int {
+	total := 0
+	for i, piece := range preTokenPattern.FindAllString(text, -1) {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			break
+		}
+		total += t.countPiece(piece)
+	}
+	return total
+}
+
+func (t *bpeTokenizer) countPiece(piece string) int {
+	t.mu.Lock()
+	n, ok := t.cache[piece]
+	t.mu.Unlock()
+	if ok {
+		return n
+	}
+
+	// The symbols form a linked list and the candidate merges a heap, so
+	// long runs of a character do not rescan every pair for each merge
+	symbols := make([]bpeSymbol, len(piece))
+	for i := range symbols {
+		symbols[i] = bpeSymbol{text: t.byteRune[piece[i]], prev: i - 1, next: i + 1}
+	}
+	symbols[len(symbols)-1].next = -1
+	merges := &mergeQueue{}
+	push := func(left int) {
+		right := symbols[left].next
+		if right < 0 {
+			return
+		}
+		if rank, ok := t.ranks[[2]string{symbols[left].text, symbols[right].text}]; ok {
+			heap.Push(merges, bpeMerge{rank: rank, left: left, right: right, size: len(symbols[left].text) + len(symbols[right].text)})
+		}
+	}
+	for i := range symbols {
+		push(i)
+	}
+
+	count := len(symbols)
+	for merges.Len() > 0 {
+		merge := heap.Pop(merges).(bpeMerge)
+		left, right := &symbols[merge.left], &symbols[merge.right]
+		// Merges made stale by an earlier one are skipped
+		if left.text == "" || right.text == "" || left.next != merge.right || len(left.text)+len(right.text) != merge.size {
+			continue
+		}
+		left.text += right.text
+		left.next = right.next
+		if right.next >= 0 {
+			symbols[right.next].prev = merge.left
+		}
+		right.text = ""
+		count--
+		if left.prev >= 0 {
+			push(left.prev)
+		}
+		push(merge.left)
+	}
+
+	// Long pieces are rarely seen twice and would only grow the cache
+	if len(piece) <= maxCachedPiece {
+		t.mu.Lock()
+		if len(t.cache) >= maxCachedPieces {
+			t.cache = make(map[string]int)
+		}
+		t.cache[piece] = count
+		t.mu.Unlock()
+	}
+	return count
+}
+
+// bpeSymbol is a symbol of a piece being merged, linked to its neighbours
+type bpeSymbol struct {
+	text       string
+	prev, next int
+}
+
+// bpeMerge is a candidate merge of the symbols at left and right, size is
+// their length when it was found to tell whether either changed since
+type bpeMerge struct {
+	rank, left, right, size int
+}
+
+// mergeQueue orders candidate merges by rank, then from left to right
+type mergeQueue []bpeMerge
+
+func (m mergeQueue) Len() int { return len(m) }
+func (m mergeQueue) Less(i, j int) bool {
+	if m[i].rank != m[j].rank {
+		return m[i].rank < m[j].rank
+	}
+	return m[i].left < m[j].left
+}
+func (m mergeQueue) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m *mergeQueue) Push(x any)   { *m = append(*m, x.(bpeMerge)) }
+func (m *mergeQueue) Pop() any {
+	old := *m
+	x := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return x
+}
+
+// bytesToUnicode maps every byte to a printable rune, as GPT-2 does, so that
+// merges can be stored as plain text.
+func bytesToUnicode() map[byte]rune {
+	m := make(map[byte]rune, 256)
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			m[byte(b)] = rune(b)
+		} else {
+			m[byte(b)] = rune(256 + n)
+			n++
+		}
+	}
+	return m
+}
+
+// CountTokens estimates the tokens of text with the tokenizer of config, one
+// per four characters without one
+func CountTokens(ctx context.Context, config Config, text string) int {
+	if config.Tokenizer == nil {
+		return charsTokenizer{}.Count(ctx, text)
+	}
+	return config.Tokenizer.Count(ctx, text)
+}
+
+// FileTokens records the token estimate and outcome for a single file
+type FileTokens struct {
+	Path   string
+	Tokens int
+	Status string
+}
+
+// TokenReport collects the per file token estimates of a run. Total counts
+// the whole synopsis, Overhead the parts of it that are not files.
+type TokenReport struct {
+	Tokenizer string
+	Budget    int
+	Total     int
+	Overhead  int
+	Files     []FileTokens
+}
+
+func (r *TokenReport) addOverhead(tokens int) {
+	r.Overhead += tokens
+	r.Total += tokens
+}
+
+func (r *TokenReport) add(path string, tokens int, status string) {
+	r.Files = append(r.Files, FileTokens{Path: path, Tokens: tokens, Status: status})
+	if status != "skipped" {
+		r.Total += tokens
+	}
+}
+
+// String formats the report for printing at the end of a run
+func (r *TokenReport) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Token report (%s tokenizer)\n", r.Tokenizer)
+	if r.Overhead > 0 {
+		fmt.Fprintf(&builder, "  %8d  %-10s %s\n", r.Overhead, "overhead", "header, statistics, tree and context")
+	}
+	for _, f := range r.Files {
+		fmt.Fprintf(&builder, "  %8d  %-10s %s\n", f.Tokens, f.Status, f.Path)
+	}
+	if r.Budget > 0 {
+		fmt.Fprintf(&builder, "Total tokens: %d of %d\n", r.Total, r.Budget)
+	} else {
+		fmt.Fprintf(&builder, "Total tokens: %d\n", r.Total)
+	}
+	return builder.String()
+}
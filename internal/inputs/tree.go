@@ -0,0 +1,92 @@
+package inputs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// TreeEntry is a file of the directory tree overview
+type TreeEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Lines  int    `json:"lines,omitempty"`
+	Status string `json:"status"`
+}
+
+// Statuses of tree entries besides the included and summarized ones
+const (
+	statusBinary    = "skipped (binary)"
+	statusBudget    = "skipped (budget)"
+	statusExcluded  = "skipped (excluded)"
+	statusError     = "skipped (error)"
+	statusUnchanged = "unchanged"
+	statusDeleted   = "deleted"
+)
+
+// countLines counts the lines of content, a last line without newline included
+func countLines(content []byte) int {
+	lines := 0
+	for _, b := range content {
+		if b == '\n' {
+			lines++
+		}
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
+
+// sortedTree returns a copy of the entries sorted by path
+func sortedTree(entries []TreeEntry) []TreeEntry {
+	sorted := make([]TreeEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+	return sorted
+}
+
+// formatTree draws the entries as an indented tree with one annotated line
+// per file
+func formatTree(entries []TreeEntry) string {
+	var builder strings.Builder
+	var openDirs []string
+	for _, entry := range sortedTree(entries) {
+		parts := strings.Split(entry.Path, string(os.PathSeparator))
+		dirs := parts[:len(parts)-1]
+
+		// Keep the directories shared with the previous entry
+		common := 0
+		for common < len(dirs) && common < len(openDirs) && dirs[common] == openDirs[common] {
+			common++
+		}
+		for i := common; i < len(dirs); i++ {
+			fmt.Fprintf(&builder, "%s%s/\n", strings.Repeat("  ", i), dirs[i])
+		}
+		openDirs = dirs
+
+		fmt.Fprintf(&builder, "%s%s (%s", strings.Repeat("  ", len(dirs)), parts[len(parts)-1], formatSize(entry.Size))
+		switch {
+		case entry.Lines == 1:
+			builder.WriteString(", 1 line")
+		case entry.Lines > 1:
+			fmt.Fprintf(&builder, ", %d lines", entry.Lines)
+		}
+		fmt.Fprintf(&builder, ", %s)\n", entry.Status)
+	}
+	return builder.String()
+}
@@ -0,0 +1,133 @@
+package inputs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// Snapshotter maps the files of the working tree that are not ignored to
+// their stamp, so two snapshots tell which files changed. The ignore rules
+// are kept between snapshots and only read again once a .gitignore file,
+// .git/info/exclude or the index changed.
+type Snapshotter struct {
+	config  Config
+	matcher gitignore.Matcher
+	source  stampedSource
+	walker  Source
+	// ignoreFiles are the .gitignore files of the last walk, rules the
+	// stamp of the files the matcher was built from
+	ignoreFiles []string
+	rules       string
+}
+
+// NewSnapshotter returns a Snapshotter of the working tree of config
+func NewSnapshotter(config Config) (*Snapshotter, error) {
+	if config.Ref != "" {
+		return nil, fmt.Errorf("only the working tree can be watched, not %q", config.Ref)
+	}
+	return &Snapshotter{config: config}, nil
+}
+
+// Snapshot scans the working tree
+func (s *Snapshotter) Snapshot() (map[string]string, error) {
+	for {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+		snapshot, ignoreFiles, err := s.walk()
+		if err != nil {
+			return nil, err
+		}
+		// A new .gitignore file changes the rules of this walk already
+		if strings.Join(ignoreFiles, "\n") == strings.Join(s.ignoreFiles, "\n") {
+			return snapshot, nil
+		}
+		s.ignoreFiles = ignoreFiles
+		s.rules = ""
+	}
+}
+
+// load reads the ignore rules and opens the source again if the files they
+// come from changed since the last time
+func (s *Snapshotter) load() error {
+	files := append([]string{filepath.Join(".git", "index"), filepath.Join(".git", "info", "exclude")}, s.ignoreFiles...)
+	var stamp strings.Builder
+	for _, relPath := range files {
+		info, err := os.Stat(filepath.Join(s.config.RepoPath, relPath))
+		if err != nil {
+			fmt.Fprintf(&stamp, "%s -\n", relPath)
+			continue
+		}
+		fmt.Fprintf(&stamp, "%s %d %d\n", relPath, info.Size(), info.ModTime().UnixNano())
+	}
+	if s.matcher != nil && stamp.String() == s.rules {
+		return nil
+	}
+
+	matcher, err := LoadGitignore(s.config)
+	if err != nil {
+		return err
+	}
+	source, err := OpenSource(s.config)
+	if err != nil {
+		return err
+	}
+	stamps, ok := source.(stampedSource)
+	if !ok {
+		return fmt.Errorf("the files of the source can not be watched")
+	}
+	s.matcher, s.walker, s.source, s.rules = matcher, source, stamps, stamp.String()
+	return nil
+}
+
+// walk stamps the files not ignored and lists every .gitignore file
+func (s *Snapshotter) walk() (map[string]string, []string, error) {
+	snapshot := make(map[string]string)
+	var ignoreFiles []string
+	err := s.walker.Walk(func(relPath string, size int64) error {
+		if filepath.Base(relPath) == ".gitignore" {
+			ignoreFiles = append(ignoreFiles, relPath)
+		}
+		if s.matcher.Match(strings.Split(relPath, string(os.PathSeparator)), false) {
+			return nil
+		}
+		stamp, err := s.source.Stamp(relPath)
+		// Files removed while walking show up as removed next time
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		snapshot[relPath] = stamp
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(ignoreFiles)
+	return snapshot, ignoreFiles, nil
+}
+
+// ChangedFiles lists the files added, removed or modified between two
+// snapshots in path order
+func ChangedFiles(before, after map[string]string) []string {
+	var changed []string
+	for relPath, stamp := range after {
+		if before[relPath] != stamp {
+			changed = append(changed, relPath)
+		}
+	}
+	for relPath := range before {
+		if _, ok := after[relPath]; !ok {
+			changed = append(changed, relPath)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"reposyn/synopsis"
+)
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// mcpProtocolVersions are the MCP versions understood, the first one is
+// answered to clients asking for another
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// defaultSearchResults is the most matches a search returns by default
+const defaultSearchResults = 100
+
+// rpcRequest is a JSON-RPC request, or a notification if it has no id
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool is a tool offered to MCP clients, call returns its text output
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+	call        func(ctx context.Context, s *mcpServer, arguments json.RawMessage) (string, error)
+}
+
+// schema is the input schema of a tool with the given properties
+func schema(properties map[string]any, required ...string) map[string]any {
+	s := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func property(kind string, description string) map[string]any {
+	return map[string]any{"type": kind, "description": description}
+}
+
+var mcpTools = []mcpTool{
+	{
+		Name:        "list_files",
+		Description: "List the files of the repository that are not ignored, with their size in bytes and their status in the synopsis",
+		InputSchema: schema(map[string]any{}),
+		call: func(ctx context.Context, s *mcpServer, arguments json.RawMessage) (string, error) {
+			tree, err := synopsis.NewBuilder(s.synopsisOptions()).Tree(ctx)
+			if err != nil {
+				return "", err
+			}
+			var builder bytes.Buffer
+			for _, entry := range tree {
+				fmt.Fprintf(&builder, "%s\t%d\t%s\n", entry.Path, entry.Size, entry.Status)
+			}
+			return builder.String(), nil
+		},
+	},
+	{
+		Name:        "read_file",
+		Description: "Read a file of the repository as it appears in the synopsis, with secrets redacted",
+		InputSchema: schema(map[string]any{
+			"path": property("string", "Path of the file relative to the repository root"),
+		}, "path"),
+		call: func(ctx context.Context, s *mcpServer, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil || args.Path == "" {
+				return "", fmt.Errorf("path is required")
+			}
+			return synopsis.NewBuilder(s.synopsisOptions()).File(ctx, args.Path)
+		},
+	},
+	{
+		Name:        "get_synopsis",
+		Description: "Get the synopsis of the repository: its statistics, directory tree, files and instructions",
+		InputSchema: schema(map[string]any{
+			"format":     property("string", "Output format: xml, markdown, json or jsonl"),
+			"ref":        property("string", "Commit, branch or tag to summarize instead of the working tree"),
+			"since":      property("string", "Only include files changed between this ref and HEAD"),
+			"diff":       property("string", "Only include files changed in a range like main..feature"),
+			"max_tokens": property("integer", "Token budget for the synopsis, 0 for unlimited"),
+		}),
+		call: func(ctx context.Context, s *mcpServer, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Format    string `json:"format"`
+				Ref       string `json:"ref"`
+				Since     string `json:"since"`
+				Diff      string `json:"diff"`
+				MaxTokens int    `json:"max_tokens"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", err
+			}
+			opts := s.synopsisOptions()
+			if args.Format != "" {
+				opts.Format = args.Format
+			}
+			if args.Ref != "" {
+				opts.Ref = args.Ref
+			}
+			if args.Since != "" {
+				opts.Since = args.Since
+			}
+			if args.Diff != "" {
+				opts.Diff = args.Diff
+			}
+			if args.MaxTokens != 0 {
+				opts.MaxTokens = args.MaxTokens
+			}
+			var buffer bytes.Buffer
+			if _, err := synopsis.NewBuilder(opts).Build(ctx, &buffer); err != nil {
+				return "", err
+			}
+			return buffer.String(), nil
+		},
+	},
+	{
+		Name:        "search",
+		Description: "Search the files of the repository for a text or regular expression, returning path:line: text for each matching line",
+		InputSchema: schema(map[string]any{
+			"query":       property("string", "Text to search for"),
+			"regex":       property("boolean", "Treat the query as a Go regular expression"),
+			"max_results": property("integer", fmt.Sprintf("Most matches returned, %d by default", defaultSearchResults)),
+		}, "query"),
+		call: func(ctx context.Context, s *mcpServer, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Query      string `json:"query"`
+				Regex      bool   `json:"regex"`
+				MaxResults int    `json:"max_results"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil || args.Query == "" {
+				return "", fmt.Errorf("query is required")
+			}
+			expr := regexp.QuoteMeta(args.Query)
+			if args.Regex {
+				expr = args.Query
+			}
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				return "", err
+			}
+			if args.MaxResults <= 0 {
+				args.MaxResults = defaultSearchResults
+			}
+
+			matches, err := synopsis.NewBuilder(s.synopsisOptions()).Search(ctx, pattern, args.MaxResults)
+			if err != nil {
+				return "", err
+			}
+			if len(matches) == 0 {
+				return "No matches", nil
+			}
+			var builder bytes.Buffer
+			for _, match := range matches {
+				fmt.Fprintf(&builder, "%s:%d: %s\n", match.Path, match.Line, match.Text)
+			}
+			return builder.String(), nil
+		},
+	},
+	{
+		Name:        "repo_stats",
+		Description: "Get the statistics of the repository: recent commits, contributors, languages, most changed files, tags and branches",
+		InputSchema: schema(map[string]any{}),
+		call: func(ctx context.Context, s *mcpServer, arguments json.RawMessage) (string, error) {
+			var buffer bytes.Buffer
+			if err := synopsis.NewBuilder(s.synopsisOptions()).RepoStats(ctx, &buffer); err != nil {
+				return "", err
+			}
+			return buffer.String(), nil
+		},
+	},
+}
+
+// mcpServer answers MCP requests about the repository of its options
+type mcpServer struct {
+	opts     options
+	cacheDir string
+}
+
+func (s *mcpServer) synopsisOptions() synopsis.Options {
+	opts := s.opts.synopsisOptions()
+	opts.CacheDir = s.cacheDir
+	return opts
+}
+
+// serveMCP answers the newline delimited JSON-RPC messages of the Model
+// Context Protocol read from r on w, until r is closed or ctx is done
+func serveMCP(ctx context.Context, opts options, r io.Reader, w io.Writer) error {
+	s := &mcpServer{opts: opts, cacheDir: cacheDir(opts)}
+	reader := bufio.NewReader(r)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	for ctx.Err() == nil {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if response := s.handle(ctx, line); response != nil {
+				if err := encoder.Encode(response); err != nil {
+					return err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle answers a message, notifications get no response
+func (s *mcpServer) handle(ctx context.Context, message []byte) *rpcResponse {
+	var request rpcRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}}
+	}
+	if request.ID == nil {
+		return nil
+	}
+
+	response := &rpcResponse{JSONRPC: "2.0", ID: request.ID}
+	result, rpcErr := s.call(ctx, request.Method, request.Params)
+	if rpcErr != nil {
+		response.Error = rpcErr
+	} else {
+		response.Result = result
+	}
+	return response
+}
+
+func (s *mcpServer) call(ctx context.Context, method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "initialize":
+		var args struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(params, &args)
+		version := mcpProtocolVersions[0]
+		for _, known := range mcpProtocolVersions {
+			if args.ProtocolVersion == known {
+				version = known
+			}
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "reposyn", "version": "dev"},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": mcpTools}, nil
+	case "tools/call":
+		var args struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		if args.Arguments == nil {
+			args.Arguments = json.RawMessage("{}")
+		}
+		for _, tool := range mcpTools {
+			if tool.Name != args.Name {
+				continue
+			}
+			// Failed tools are reported to the model, not as protocol errors
+			text, err := tool.call(ctx, s, args.Arguments)
+			if err != nil {
+				return toolResult(err.Error(), true), nil
+			}
+			return toolResult(text, false), nil
+		}
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown tool %q", args.Name)}
+	}
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("method %q not found", method)}
+}
+
+func toolResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
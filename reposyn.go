@@ -1,87 +1,425 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"runtime"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"reposyn/internal/inputs"
+	"reposyn/synopsis"
 
+	"github.com/BurntSushi/toml"
 	"github.com/urfave/cli/v3"
 	"golang.design/x/clipboard"
 )
 
-// Main function, create the repo summary and writes to destination
-func summarizeRepo(targetDir string, outputFile string, wantClipboard bool,
-	ignore string, summary string) error {
+// options collects the command line arguments of a run
+type options struct {
+	target     string
+	output     string
+	clipboard  bool
+	ignore     string
+	summary    string
+	maxTokens  int
+	tokenizer  string
+	format     string
+	order      string
+	since      string
+	diff       string
+	ref        string
+	noConfig   bool
+	includeExt string
+	excludeExt string
+	noTree     bool
+	tracked    bool
+	noRedact   bool
+	redactFile string
+	chunkSize  string
+	quiet      bool
+	strict     bool
+	timeout    time.Duration
+	noCache    bool
+	statsDepth int
+	churnDepth int
+	// exclude are patterns left out besides the ignored files, not a flag
+	exclude []string
+	// explicit names the project config settings given by flags
+	explicit map[string]bool
+}
 
-	start := time.Now()
+// projectFlags maps the flags to the project config settings they override
+var projectFlags = map[string]string{
+	"ignore":       "ignore",
+	"summary":      "summary",
+	"format":       "format",
+	"tokenizer":    "tokenizer",
+	"max-tokens":   "max_tokens",
+	"order":        "order",
+	"tracked-only": "tracked_only",
+}
+
+func optionsFromFlags(c *cli.Command) options {
+	explicit := make(map[string]bool)
+	for flag, setting := range projectFlags {
+		if c.IsSet(flag) {
+			explicit[setting] = true
+		}
+	}
+	return options{
+		target:     c.String("target"),
+		output:     c.String("output"),
+		clipboard:  c.Bool("clipboard"),
+		ignore:     c.String("ignore"),
+		summary:    c.String("summary"),
+		maxTokens:  int(c.Int("max-tokens")),
+		tokenizer:  c.String("tokenizer"),
+		format:     c.String("format"),
+		order:      c.String("order"),
+		since:      c.String("since"),
+		diff:       c.String("diff"),
+		ref:        c.String("ref"),
+		noConfig:   c.Bool("no-config"),
+		includeExt: c.String("include-ext"),
+		excludeExt: c.String("exclude-ext"),
+		noTree:     c.Bool("no-tree"),
+		tracked:    c.Bool("tracked-only"),
+		noRedact:   c.Bool("no-redact"),
+		redactFile: c.String("redact-rules"),
+		chunkSize:  c.String("chunk-size"),
+		quiet:      c.Bool("quiet"),
+		strict:     c.Bool("strict"),
+		timeout:    c.Duration("timeout"),
+		noCache:    c.Bool("no-cache"),
+		statsDepth: int(c.Int("stats-commits")),
+		churnDepth: int(c.Int("churn-commits")),
+		explicit:   explicit,
+	}
+}
 
-	repoPath, err := inputs.FindGitRoot(targetDir)
+// synopsisOptions converts the arguments, settings left empty and not given
+// by a flag are taken from the project config file
+func (opts options) synopsisOptions() synopsis.Options {
+	return synopsis.Options{
+		Target:              opts.target,
+		IgnorePatterns:      splitPatterns(opts.ignore),
+		SummaryPatterns:     splitPatterns(opts.summary),
+		Format:              opts.format,
+		Tokenizer:           opts.tokenizer,
+		MaxTokens:           opts.maxTokens,
+		Order:               opts.order,
+		Ref:                 opts.ref,
+		Since:               opts.since,
+		Diff:                opts.diff,
+		IgnoreProjectConfig: opts.noConfig,
+		IncludeExtensions:   splitPatterns(opts.includeExt),
+		ExcludeExtensions:   splitPatterns(opts.excludeExt),
+		NoTree:              opts.noTree,
+		TrackedOnly:         opts.tracked,
+		NoRedact:            opts.noRedact,
+		RedactRules:         opts.redactFile,
+		StatsCommits:        opts.statsDepth,
+		ChurnCommits:        opts.churnDepth,
+		ExcludePaths:        opts.exclude,
+		Explicit:            opts.explicit,
+	}
+}
+
+// cacheDir is the cache directory of a run, empty with --no-cache. Without
+// a user cache dir every file is processed each run.
+func cacheDir(opts options) string {
+	if opts.noCache {
+		return ""
+	}
+	dir, err := synopsis.DefaultCacheDir()
 	if err != nil {
-		return fmt.Errorf("error finding repository: %w", err)
+		return ""
+	}
+	return dir
+}
+
+// splitPatterns turns a comma separated flag into a list of patterns
+func splitPatterns(patterns string) []string {
+	if patterns == "" {
+		return nil
+	}
+	return strings.Split(patterns, ",")
+}
+
+// parseChunkSize reads a chunk size like 200000, 500KB, 2MB, 8000tokens or
+// 8k tokens
+func parseChunkSize(size string) (int, bool, error) {
+	value := strings.ReplaceAll(strings.ToLower(size), " ", "")
+	tokens := false
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "tokens"):
+		value, tokens = strings.TrimSuffix(value, "tokens"), true
+	case strings.HasSuffix(value, "mb"):
+		value, multiplier = strings.TrimSuffix(value, "mb"), 1<<20
+	case strings.HasSuffix(value, "kb"):
+		value, multiplier = strings.TrimSuffix(value, "kb"), 1<<10
+	case strings.HasSuffix(value, "b"):
+		value = strings.TrimSuffix(value, "b")
+	}
+	if tokens && strings.HasSuffix(value, "k") {
+		value, multiplier = strings.TrimSuffix(value, "k"), 1000
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		return 0, false, fmt.Errorf("invalid chunk size %q, use e.g. 200000, 500KB, 2MB or 8000tokens", size)
+	}
+	return number * multiplier, tokens, nil
+}
+
+// chunkPath numbers the output file of a part, repo-synopsis.txt becomes
+// repo-synopsis-001.txt
+func chunkPath(output string, part int) string {
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(output, ext), part, ext)
+}
+
+// chunkPattern matches the file names of the parts of output
+func chunkPattern(output string) string {
+	base := filepath.Base(output)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-[0-9][0-9][0-9]" + ext
+}
+
+// Main function, create the repo summary and writes to destination. A
+// cancelled ctx stops the run and removes the partial output.
+func summarizeRepo(ctx context.Context, opts options) error {
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	outputFile := opts.output
+	wantClipboard := opts.clipboard
+	toStdout := outputFile == "-" && !wantClipboard
+
+	// Messages go to stderr so stdout only carries the synopsis
+	var messages io.Writer = os.Stderr
+	if opts.quiet {
+		messages = io.Discard
+	}
+
+	synopsisOpts := opts.synopsisOptions()
+	synopsisOpts.Log = messages
+	synopsisOpts.CacheDir = cacheDir(opts)
+
+	var chunks []string
+	if opts.chunkSize != "" {
+		if wantClipboard || toStdout {
+			return fmt.Errorf("chunk-size needs an output file")
+		}
+		size, tokens, err := parseChunkSize(opts.chunkSize)
+		if err != nil {
+			return err
+		}
+		synopsisOpts.ChunkSize, synopsisOpts.ChunkTokens = size, tokens
+		// The parts of the last run are not files of the repo
+		synopsisOpts.ExcludePaths = append(synopsisOpts.ExcludePaths, chunkPattern(outputFile))
 	}
-	fmt.Printf("Found repo at %v\n", repoPath)
+	builder := synopsis.NewBuilder(synopsisOpts)
 
-	if wantClipboard {
-		err = clipboard.Init()
+	var result *synopsis.Result
+	if opts.chunkSize != "" {
+		res, err := builder.BuildChunks(ctx, func(part int, total int) (io.WriteCloser, error) {
+			path := chunkPath(outputFile, part)
+			file, err := os.Create(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create file: %w", err)
+			}
+			chunks = append(chunks, path)
+			return file, nil
+		})
+		if err != nil {
+			for _, path := range chunks {
+				os.Remove(path)
+			}
+			return runError(ctx, err)
+		}
+		// Parts left by an earlier run with more parts would pass for
+		// parts of this one
+		for part := len(chunks) + 1; ; part++ {
+			if err := os.Remove(chunkPath(outputFile, part)); err != nil {
+				break
+			}
+		}
+		result = res
+	} else if wantClipboard {
+		if err := clipboard.Init(); err != nil {
+			return fmt.Errorf("error while making clipboard: %w", err)
+		}
+		var buffer bytes.Buffer
+		res, err := builder.Build(ctx, &buffer)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while making clipboard %v\n", err)
-			os.Exit(1)
+			return runError(ctx, err)
 		}
-		tempFile, err := os.CreateTemp("", "prefix-*.txt")
-		defer tempFile.Close()
+		result = res
+		clipboard.Write(clipboard.FmtText, buffer.Bytes())
+	} else if toStdout {
+		res, err := builder.Build(ctx, os.Stdout)
 		if err != nil {
-			log.Fatal(err)
+			return runError(ctx, err)
 		}
-		outputFile = tempFile.Name()
+		result = res
 	} else {
-		if err := os.Remove(outputFile); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove existing file: %v", err)
+		file, err := os.Create(outputFile)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
 		}
-		if _, err := os.Create(outputFile); err != nil {
-			return fmt.Errorf("failed to create file: %v", err)
+		defer file.Close()
+		res, err := builder.Build(ctx, file)
+		if err != nil {
+			file.Close()
+			os.Remove(outputFile)
+			return runError(ctx, err)
+		}
+		result = res
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
 		}
 	}
 
-	config := inputs.Config{
-		InputDir:        repoPath,
-		OutputFile:      outputFile,
-		TextExtensions:  inputs.DefaultTextExtensions(),
-		NumWorkers:      runtime.NumCPU(),
-		RepoPath:        repoPath,
-		Clipboard:       wantClipboard,
-		IgnorePatterns:  strings.Split(ignore, ","),
-		SummaryPatterns: strings.Split(summary, ","),
+	elapsed := time.Since(start).Round(100 * time.Millisecond)
+	switch {
+	case len(chunks) > 0:
+		fmt.Fprintf(messages, "Files successfully concatenated to %d parts: %s\n", len(chunks), strings.Join(chunks, ", "))
+	case wantClipboard:
+		fmt.Fprintf(messages, "Files successfully concatenated to clipboard\n")
+	case toStdout:
+		fmt.Fprintf(messages, "Files successfully concatenated to stdout\n")
+	default:
+		fmt.Fprintf(messages, "Files successfully concatenated to %s\n", outputFile)
+	}
+	fmt.Fprint(messages, result.Tokens)
+	if len(result.Binary) > 0 {
+		fmt.Fprintf(messages, "Skipped %d binary files:\n", len(result.Binary))
+		for _, path := range result.Binary {
+			fmt.Fprintf(messages, "  %s\n", path)
+		}
+	}
+	if len(result.Redactions) > 0 {
+		fmt.Fprintf(messages, "Redacted %d secrets:\n", len(result.Redactions))
+		for _, redaction := range result.Redactions {
+			fmt.Fprintf(messages, "  %s\n", redaction)
+		}
 	}
+	if len(result.Errors) > 0 {
+		fmt.Fprintf(messages, "Could not process %d items, the synopsis is incomplete:\n", len(result.Errors))
+		for _, failure := range result.Errors {
+			fmt.Fprintf(messages, "  %s\n", failure)
+		}
+	}
+	if result.Cache.Enabled {
+		fmt.Fprintf(messages, "Cache: %d hits, %d misses\n", result.Cache.Hits, result.Cache.Misses)
+	}
+	fmt.Fprintf(messages, "Operation took %s\n", elapsed)
 
-	inputs.InputRepoStats(config)
+	if opts.strict && len(result.Errors) > 0 {
+		return fmt.Errorf("%d errors in strict mode", len(result.Errors))
+	}
+	return nil
+}
 
-	fmt.Printf("Starting file concatenation with %d workers...\n", config.NumWorkers)
-	if err := inputs.MergeFiles(config); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+// runError explains errors caused by an interrupt or the timeout
+func runError(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("timed out, no output written: %w", err)
+	case context.Canceled:
+		return fmt.Errorf("interrupted, no output written: %w", err)
 	}
+	return err
+}
 
-	inputs.InputContext(config)
+// showConfig prints the configuration a run with opts would use, merged
+// from the flags, the project config file and the defaults
+func showConfig(opts options, w io.Writer) error {
+	effective, path, err := synopsis.NewBuilder(opts.synopsisOptions()).EffectiveOptions()
+	if err != nil {
+		return err
+	}
 
-	elapsed := time.Since(start).Round(100 * time.Millisecond)
-	if !wantClipboard {
-		fmt.Printf("Files successfully concatenated to %s\n", config.OutputFile)
+	if path != "" {
+		fmt.Fprintf(w, "# Project config: %s\n", path)
 	} else {
-		contents, err := os.ReadFile(outputFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		clipboard.Write(clipboard.FmtText, contents)
-		fmt.Printf("Files successfully concatenated to clipboard\n")
+		fmt.Fprintf(w, "# No project config file found\n")
+	}
+	config := struct {
+		Ignore      []string `toml:"ignore"`
+		Summary     []string `toml:"summary"`
+		Extensions  []string `toml:"extensions"`
+		Format      string   `toml:"format"`
+		Tokenizer   string   `toml:"tokenizer"`
+		MaxTokens   int      `toml:"max_tokens"`
+		Order       string   `toml:"order"`
+		Prompt      string   `toml:"prompt"`
+		TrackedOnly bool     `toml:"tracked_only"`
+	}{
+		Ignore:      append([]string{}, effective.IgnorePatterns...),
+		Summary:     append([]string{}, effective.SummaryPatterns...),
+		Extensions:  append([]string{}, effective.ExtraExtensions...),
+		Format:      effective.Format,
+		Tokenizer:   effective.Tokenizer,
+		MaxTokens:   effective.MaxTokens,
+		Order:       effective.Order,
+		Prompt:      effective.Prompt,
+		TrackedOnly: effective.TrackedOnly,
+	}
+	return toml.NewEncoder(w).Encode(config)
+}
+
+// watchRepo writes the synopsis and writes it again whenever files of the
+// repository change, until ctx is done
+func watchRepo(ctx context.Context, opts options, interval time.Duration, debounce time.Duration) error {
+	if opts.output == "-" && !opts.clipboard {
+		return fmt.Errorf("watch needs an output file or the clipboard")
 	}
-	fmt.Printf("Operation took %s\n", elapsed)
+	// The synopsis must neither contain its last version nor trigger the
+	// next one by being written
+	if !opts.clipboard {
+		opts.exclude = []string{filepath.Base(opts.output), chunkPattern(opts.output)}
+	}
+	// A failed run is reported, the next change may fix it
+	if err := summarizeRepo(ctx, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to summarize repo: %v\n", err)
+	}
+
+	var messages io.Writer = os.Stderr
+	if opts.quiet {
+		messages = io.Discard
+	}
+	synopsisOpts := opts.synopsisOptions()
+	synopsisOpts.Log = messages
+	watchOpts := synopsis.WatchOptions{Interval: interval, Debounce: debounce}
+	return synopsis.NewBuilder(synopsisOpts).Watch(ctx, watchOpts, func(ctx context.Context, changed []string) error {
+		return summarizeRepo(ctx, opts)
+	})
+}
 
+// clearCache removes the cache directory
+func clearCache(w io.Writer) error {
+	dir, err := synopsis.DefaultCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := synopsis.ClearCache(dir); err != nil {
+		return fmt.Errorf("error clearing cache: %w", err)
+	}
+	fmt.Fprintf(w, "Cleared cache at %s\n", dir)
 	return nil
 }
 
@@ -94,13 +432,13 @@ func main() {
 				Name:    "target",
 				Aliases: []string{"t"},
 				Value:   "./",
-				Usage:   "Target directory path",
+				Usage:   "Target directory path or git URL (https, ssh or file://)",
 			},
 			&cli.StringFlag{
 				Name:    "output",
 				Aliases: []string{"o"},
 				Value:   "repo-synopsis.txt",
-				Usage:   "Output text file",
+				Usage:   "Output text file, - for stdout",
 			},
 			&cli.StringFlag{
 				Name:    "ignore",
@@ -120,15 +458,166 @@ func main() {
 				Value:   false,
 				Usage:   "Write output to clipboard, will ignore output argument if set",
 			},
+			&cli.IntFlag{
+				Name:  "max-tokens",
+				Value: 0,
+				Usage: "Token budget for the synopsis, summarize or drop files once exceeded (0 = unlimited)",
+			},
+			&cli.StringFlag{
+				Name:  "tokenizer",
+				Usage: "Tokenizer used to estimate tokens, one of bpe (default), chars",
+			},
+			&cli.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Usage:   "Output format, one of xml (default), markdown, json, jsonl",
+			},
+			&cli.StringFlag{
+				Name:  "order",
+				Usage: "File order, path (default) or important (readmes, manifests and entry points first)",
+			},
+			&cli.StringFlag{
+				Name:  "ref",
+				Usage: "Snapshot a commit, branch or tag instead of the working tree",
+			},
+			&cli.StringFlag{
+				Name:  "since",
+				Usage: "Only include files changed between this ref and HEAD, with their diff",
+			},
+			&cli.StringFlag{
+				Name:  "diff",
+				Usage: "Only include files changed in a range like main..feature or main...feature, with their diff",
+			},
+			&cli.StringFlag{
+				Name:  "include-ext",
+				Usage: "Comma separated extensions, only include files with these e.g., '.go,.md'",
+			},
+			&cli.StringFlag{
+				Name:  "exclude-ext",
+				Usage: "Comma separated extensions of files to leave out e.g., '.svg,.lock'",
+			},
+			&cli.BoolFlag{
+				Name:  "tracked-only",
+				Usage: "Only include files tracked by git, taken from the index",
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Keep secrets like API keys and passwords instead of replacing them with [REDACTED:<kind>]",
+			},
+			&cli.StringFlag{
+				Name:  "redact-rules",
+				Usage: "TOML file with additional [[rules]] of kind and pattern (a regular expression) to redact",
+			},
+			&cli.StringFlag{
+				Name:  "chunk-size",
+				Usage: "Split the output into numbered parts of at most this size, in bytes (200000, 500KB, 2MB) or tokens (8000tokens)",
+			},
+			&cli.BoolFlag{
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Usage:   "Do not print progress and reports, which go to stderr otherwise",
+			},
+			&cli.BoolFlag{
+				Name:  "strict",
+				Usage: "Fail if any file can not be processed instead of listing it as skipped",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Stop the run after this duration, e.g. 30s or 5m (0 = no timeout)",
+			},
+			&cli.BoolFlag{
+				Name:  "no-tree",
+				Usage: "Leave out the directory tree overview before the files",
+			},
+			&cli.IntFlag{
+				Name:  "stats-commits",
+				Usage: "Most commits counted for the repo statistics (0 = 100)",
+			},
+			&cli.IntFlag{
+				Name:  "churn-commits",
+				Usage: "Number of recent commits looked at for the most changed files (0 = 100)",
+			},
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "Process every file instead of reusing the results of earlier runs",
+			},
+			&cli.BoolFlag{
+				Name:  "no-config",
+				Usage: "Ignore the .reposyn.toml project config file",
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "config",
+				Usage: "Inspect the project configuration",
+				Commands: []*cli.Command{
+					{
+						Name:  "show",
+						Usage: "Print the effective configuration merged from flags, .reposyn.toml and defaults",
+						Action: func(ctx context.Context, c *cli.Command) error {
+							return showConfig(optionsFromFlags(c), os.Stdout)
+						},
+					},
+				},
+			},
+			{
+				Name:  "watch",
+				Usage: "Write the synopsis again whenever files of the repository change",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "interval",
+						Value: time.Second,
+						Usage: "Time between two scans of the repository",
+					},
+					&cli.DurationFlag{
+						Name:  "debounce",
+						Value: 500 * time.Millisecond,
+						Usage: "Wait until the files are unchanged for this long before writing the synopsis",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					return watchRepo(ctx, optionsFromFlags(c), c.Duration("interval"), c.Duration("debounce"))
+				},
+			},
+			{
+				Name:  "serve",
+				Usage: "Answer requests for synopses, files and the tree over HTTP",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Value: "127.0.0.1:7070",
+						Usage: "Address to listen on",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					return serveRepo(ctx, optionsFromFlags(c), c.String("addr"))
+				},
+			},
+			{
+				Name:  "mcp",
+				Usage: "Offer the repository to agents as a Model Context Protocol server over stdin and stdout",
+				Action: func(ctx context.Context, c *cli.Command) error {
+					return serveMCP(ctx, optionsFromFlags(c), os.Stdin, os.Stdout)
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "Manage the cache of processed files",
+				Commands: []*cli.Command{
+					{
+						Name:  "clear",
+						Usage: "Remove the cached files of every repository",
+						Action: func(ctx context.Context, c *cli.Command) error {
+							return clearCache(os.Stdout)
+						},
+					},
+				},
+			},
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
-			target := c.String("target")
-			output := c.String("output")
-			clipboard := c.Bool("clipboard")
-			ignore := c.String("ignore")
-			summary := c.String("summary")
+			opts := optionsFromFlags(c)
 
-			err := summarizeRepo(target, output, clipboard, ignore, summary)
+			err := summarizeRepo(ctx, opts)
 			if err != nil {
 				return fmt.Errorf("failed to summarize repo: %w", err)
 			}
@@ -137,7 +626,14 @@ func main() {
 		},
 	}
 
-	if err := app.Run(context.Background(), os.Args); err != nil {
+	// Ctrl-C stops the run, a second one kills it right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := app.Run(ctx, os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
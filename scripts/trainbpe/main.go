@@ -0,0 +1,156 @@
+// Command trainbpe learns the byte pair merges embedded in
+// internal/inputs/bpe_merges.txt from a corpus of source files.
+//
+//	go run ./scripts/trainbpe -corpus $(go env GOROOT)/src > internal/inputs/bpe_merges.txt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Must match preTokenPattern in internal/inputs/tokens.go
+var preTokenPattern = regexp.MustCompile(`'s|'t|'re|'ve|'m|'ll|'d| ?\pL+| ?\pN+| ?[^\s\pL\pN]+|\s+`)
+
+type pair [2]string
+
+type word struct {
+	symbols []string
+	count   int
+}
+
+func bytesToUnicode() map[byte]rune {
+	m := make(map[byte]rune, 256)
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			m[byte(b)] = rune(b)
+		} else {
+			m[byte(b)] = rune(256 + n)
+			n++
+		}
+	}
+	return m
+}
+
+func readCorpus(root string, maxBytes int64) (map[string]int, error) {
+	counts := make(map[string]int)
+	var total int64
+	extensions := map[string]bool{".go": true, ".md": true, ".txt": true, ".html": true, ".js": true, ".sh": true}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || total >= maxBytes {
+			return err
+		}
+		if !extensions[filepath.Ext(path)] || strings.Contains(path, "testdata") {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(content), "// Code generated") {
+			return nil
+		}
+		total += int64(len(content))
+		for _, piece := range preTokenPattern.FindAllString(string(content), -1) {
+			counts[piece]++
+		}
+		return nil
+	})
+	return counts, err
+}
+
+func train(pieces map[string]int, numMerges int) []pair {
+	byteRune := bytesToUnicode()
+	words := make([]*word, 0, len(pieces))
+	for piece, count := range pieces {
+		w := &word{count: count}
+		for i := 0; i < len(piece); i++ {
+			w.symbols = append(w.symbols, string(byteRune[piece[i]]))
+		}
+		words = append(words, w)
+	}
+
+	pairCounts := make(map[pair]int)
+	pairWords := make(map[pair]map[int]bool)
+	addPairs := func(id int, sign int) {
+		w := words[id]
+		for i := 0; i < len(w.symbols)-1; i++ {
+			p := pair{w.symbols[i], w.symbols[i+1]}
+			pairCounts[p] += sign * w.count
+			if sign > 0 {
+				if pairWords[p] == nil {
+					pairWords[p] = make(map[int]bool)
+				}
+				pairWords[p][id] = true
+			}
+		}
+	}
+	for id := range words {
+		addPairs(id, 1)
+	}
+
+	merges := make([]pair, 0, numMerges)
+	for len(merges) < numMerges {
+		var best pair
+		bestCount := 0
+		for p, c := range pairCounts {
+			if c > bestCount || (c == bestCount && (p[0]+" "+p[1]) < (best[0]+" "+best[1])) {
+				best, bestCount = p, c
+			}
+		}
+		if bestCount < 2 {
+			break
+		}
+		merges = append(merges, best)
+
+		ids := make([]int, 0, len(pairWords[best]))
+		for id := range pairWords[best] {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			w := words[id]
+			addPairs(id, -1)
+			merged := w.symbols[:0:0]
+			for i := 0; i < len(w.symbols); i++ {
+				if i < len(w.symbols)-1 && w.symbols[i] == best[0] && w.symbols[i+1] == best[1] {
+					merged = append(merged, best[0]+best[1])
+					i++
+				} else {
+					merged = append(merged, w.symbols[i])
+				}
+			}
+			w.symbols = merged
+			addPairs(id, 1)
+		}
+		delete(pairCounts, best)
+		delete(pairWords, best)
+	}
+	return merges
+}
+
+func main() {
+	corpus := flag.String("corpus", ".", "Directory with training files")
+	maxBytes := flag.Int64("max-bytes", 16<<20, "Maximum number of corpus bytes to read")
+	numMerges := flag.Int("merges", 3000, "Number of merges to learn")
+	flag.Parse()
+
+	pieces, err := readCorpus(*corpus, *maxBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("#version: reposyn bpe, %d merges\n", *numMerges)
+	for _, m := range train(pieces, *numMerges) {
+		fmt.Printf("%s %s\n", m[0], m[1])
+	}
+}
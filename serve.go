@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"reposyn/synopsis"
+)
+
+// contentTypes are the content types of the output formats, the xml format
+// is not well formed XML and served as text
+var contentTypes = map[string]string{
+	"json":     "application/json",
+	"jsonl":    "application/x-ndjson",
+	"markdown": "text/markdown; charset=utf-8",
+}
+
+// server answers requests for synopses, the options of the command line
+// are the defaults of every request
+type server struct {
+	opts     options
+	cacheDir string
+}
+
+// newServer returns the handler of the HTTP API:
+//
+//	GET /synopsis?path=...&format=...&ref=...&max_tokens=...
+//	GET /files/{path}?path=...
+//	GET /tree?path=...
+//
+// path is the repository, a local path, the files are relative to its root
+func newServer(opts options) http.Handler {
+	s := &server{opts: opts, cacheDir: cacheDir(opts)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /synopsis", s.handleSynopsis)
+	mux.HandleFunc("GET /files/{path...}", s.handleFile)
+	mux.HandleFunc("GET /tree", s.handleTree)
+	return loopbackOnly(mux)
+}
+
+// loopbackOnly rejects requests for other hosts than the loopback address.
+// A web page can point a name it controls at 127.0.0.1 and read the
+// answers of the server as its own, the Host header still carries that
+// name.
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "only requests for localhost are served", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestOptions overrides the defaults with the query parameters of r
+func (s *server) requestOptions(r *http.Request) (synopsis.Options, error) {
+	opts := s.opts
+	query := r.URL.Query()
+	for name, value := range map[string]*string{
+		"path":        &opts.target,
+		"format":      &opts.format,
+		"tokenizer":   &opts.tokenizer,
+		"order":       &opts.order,
+		"ref":         &opts.ref,
+		"since":       &opts.since,
+		"diff":        &opts.diff,
+		"ignore":      &opts.ignore,
+		"summary":     &opts.summary,
+		"include_ext": &opts.includeExt,
+		"exclude_ext": &opts.excludeExt,
+	} {
+		if query.Has(name) {
+			*value = query.Get(name)
+		}
+	}
+	if value := query.Get("max_tokens"); value != "" {
+		maxTokens, err := strconv.Atoi(value)
+		if err != nil || maxTokens < 0 {
+			return synopsis.Options{}, fmt.Errorf("invalid max_tokens %q", value)
+		}
+		opts.maxTokens = maxTokens
+	}
+	for name, value := range map[string]*bool{
+		"tracked_only": &opts.tracked,
+		"no_tree":      &opts.noTree,
+	} {
+		if query.Has(name) {
+			enabled, err := strconv.ParseBool(query.Get(name))
+			if err != nil {
+				return synopsis.Options{}, fmt.Errorf("invalid %s %q", name, query.Get(name))
+			}
+			*value = enabled
+		}
+	}
+	// Any web page can send requests to a local server, it must not make
+	// it clone repositories
+	if synopsis.IsRemote(opts.target) {
+		return synopsis.Options{}, fmt.Errorf("only local repositories can be served")
+	}
+
+	synopsisOpts := opts.synopsisOptions()
+	synopsisOpts.CacheDir = s.cacheDir
+	return synopsisOpts, nil
+}
+
+// context limits a request to the timeout of the command line, if any
+func (s *server) context(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.opts.timeout > 0 {
+		return context.WithTimeout(r.Context(), s.opts.timeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+func (s *server) handleSynopsis(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.requestOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := s.context(r)
+	defer cancel()
+
+	var buffer bytes.Buffer
+	if _, err := synopsis.NewBuilder(opts).Build(ctx, &buffer); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeOutput(w, opts.Format, &buffer)
+}
+
+func (s *server) handleFile(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.requestOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := s.context(r)
+	defer cancel()
+
+	block, err := synopsis.NewBuilder(opts).File(ctx, r.PathValue("path"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeOutput(w, opts.Format, bytes.NewBufferString(block))
+}
+
+func (s *server) handleTree(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.requestOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := s.context(r)
+	defer cancel()
+
+	tree, err := synopsis.NewBuilder(opts).Tree(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+// writeError answers with the status matching err: files that are not part
+// of the synopsis are not found, options like an unknown ref or format are
+// bad requests, other errors are failures of the server
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, synopsis.ErrNotIncluded):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, synopsis.ErrInvalidOptions):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeOutput(w http.ResponseWriter, format string, output io.Reader) {
+	contentType, ok := contentTypes[format]
+	if !ok {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	io.Copy(w, output)
+}
+
+// serveRepo answers HTTP requests on addr until ctx is done
+func serveRepo(ctx context.Context, opts options, addr string) error {
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           newServer(opts),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+	if !opts.quiet {
+		fmt.Fprintf(os.Stderr, "Serving synopses on http://%s\n", addr)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return httpServer.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,536 @@
+// Package synopsis builds AI friendly summaries of git repositories.
+//
+// A Builder walks a local repository, a git URL or a commit of either,
+// and writes the synopsis to any io.Writer:
+//
+//	builder := synopsis.NewBuilder(synopsis.Options{Target: ".", Format: "markdown"})
+//	result, err := builder.Build(ctx, os.Stdout)
+package synopsis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+
+	"reposyn/internal/inputs"
+)
+
+// TokenReport lists the token estimate and outcome of every file
+type TokenReport = inputs.TokenReport
+
+// FileTokens is the token estimate and outcome of a single file
+type FileTokens = inputs.FileTokens
+
+// Redaction is a secret replaced in the synopsis
+type Redaction = inputs.Redaction
+
+// RunError is a problem that left the synopsis incomplete, with the file and
+// the stage of the pipeline where it happened
+type RunError = inputs.RunError
+
+// TreeEntry is a file of the directory tree with what became of it
+type TreeEntry = inputs.TreeEntry
+
+// SearchMatch is a line of a file matching a search
+type SearchMatch = inputs.SearchMatch
+
+// ErrNotIncluded is returned by File for files that are ignored, missing or
+// skipped like binary files
+var ErrNotIncluded = inputs.ErrNotIncluded
+
+// CacheStats counts the files taken from the cache and those processed
+type CacheStats = inputs.CacheStats
+
+// DefaultCacheDir is the cache directory in the user cache dir, e.g.
+// ~/.cache/reposyn on Linux
+func DefaultCacheDir() (string, error) {
+	return inputs.DefaultCacheDir()
+}
+
+// ClearCache removes every cached file of the cache directory dir
+func ClearCache(dir string) error {
+	return inputs.ClearCache(dir)
+}
+
+// Options configures a Builder. The zero value summarizes the working tree
+// of the current directory in the xml format.
+type Options struct {
+	// Target is a path inside a repository or a git URL (https, ssh, file://)
+	Target string
+	// IgnorePatterns are gitignore style patterns of files to leave out
+	IgnorePatterns []string
+	// ExcludePaths are gitignore style patterns of files always left out,
+	// on top of IgnorePatterns or those of the project config, such as the
+	// output of a previous run
+	ExcludePaths []string
+	// SummaryPatterns are gitignore style patterns of files to summarize
+	SummaryPatterns []string
+	// TextExtensions are the extensions of files included without checking
+	// their content, nil for the default set. Other files are included if
+	// their content looks like text.
+	TextExtensions map[string]bool
+	// ExtraExtensions are treated as text in addition to TextExtensions
+	ExtraExtensions []string
+	// IncludeExtensions restricts the synopsis to files with these extensions
+	IncludeExtensions []string
+	// ExcludeExtensions leaves out files with these extensions
+	ExcludeExtensions []string
+	// Format is one of xml, markdown, json, jsonl
+	Format string
+	// Tokenizer is one of bpe, chars
+	Tokenizer string
+	// MaxTokens is the token budget of the synopsis, the header, statistics,
+	// tree and context included, 0 for unlimited
+	MaxTokens int
+	// Order is path or important
+	Order string
+	// Ref selects a commit, branch or tag instead of the working tree
+	Ref string
+	// Since restricts the synopsis to files changed between this ref and HEAD
+	Since string
+	// Diff restricts the synopsis to files changed in a range like main..feature
+	Diff string
+	// Prompt replaces the default instructions at the end of the synopsis
+	Prompt string
+	// TrackedOnly restricts the synopsis to the files in the git index, so
+	// untracked files can never be included
+	TrackedOnly bool
+	// NoRedact keeps secrets like API keys and passwords in the synopsis
+	NoRedact bool
+	// RedactRules is a TOML file of additional redaction rules, each with a
+	// kind and a regular expression
+	RedactRules string
+	// ChunkSize is the most bytes, or tokens with ChunkTokens, of each part
+	// written by BuildChunks
+	ChunkSize int
+	// ChunkTokens measures ChunkSize in tokens of the Tokenizer
+	ChunkTokens bool
+	// NoTree leaves out the directory tree overview before the files
+	NoTree bool
+	// CacheDir keeps the rendered files between builds, so only the files
+	// changed since an earlier build are processed again. Empty disables the
+	// cache, it is never used for git URLs.
+	CacheDir string
+	// StatsCommits is the most commits counted for the repository
+	// statistics, 0 for 100
+	StatsCommits int
+	// ChurnCommits is the number of recent commits looked at for the most
+	// changed files, 0 for 100
+	ChurnCommits int
+	// NumWorkers is the number of files read in parallel, 0 for one per CPU
+	NumWorkers int
+	// IgnoreProjectConfig skips the .reposyn.toml of the repository. Otherwise
+	// its settings fill every option left at its zero value and not named
+	// in Explicit.
+	IgnoreProjectConfig bool
+	// Explicit names the options given explicitly, by their setting in the
+	// project config file like "max_tokens" or "tracked_only". The project
+	// config does not replace them even at their zero value.
+	Explicit map[string]bool
+	// Log receives progress messages, nil to discard them
+	Log io.Writer
+}
+
+// Result describes a finished build
+type Result struct {
+	// RepoPath is the root of the summarized repository
+	RepoPath string
+	// Tokens is the token report of the included files
+	Tokens *TokenReport
+	// Binary lists the files skipped because they are not text
+	Binary []string
+	// Redactions lists the secrets replaced in the synopsis
+	Redactions []Redaction
+	// Errors lists the files that could not be processed and other problems
+	// that left the synopsis incomplete
+	Errors []RunError
+	// Cache counts the cache hits and misses if the cache is enabled
+	Cache CacheStats
+}
+
+// ErrInvalidOptions is wrapped by the errors of options that can not work
+// for the target, like an unknown format or ref, as opposed to failures
+// while building the synopsis
+var ErrInvalidOptions = errors.New("invalid options")
+
+// invalidError marks an error caused by the options
+type invalidError struct {
+	err error
+}
+
+func (e invalidError) Error() string {
+	return e.err.Error()
+}
+
+func (e invalidError) Unwrap() []error {
+	return []error{e.err, ErrInvalidOptions}
+}
+
+// Builder creates synopses with a fixed set of options
+type Builder struct {
+	opts Options
+}
+
+// NewBuilder returns a Builder for opts
+func NewBuilder(opts Options) *Builder {
+	return &Builder{opts: opts}
+}
+
+func (b *Builder) logf(format string, args ...any) {
+	if b.opts.Log != nil {
+		fmt.Fprintf(b.opts.Log, format, args...)
+	}
+}
+
+// IsRemote reports whether target is a git URL rather than a local path
+func IsRemote(target string) bool {
+	return inputs.IsRemoteURL(target)
+}
+
+// resolveRepo finds the repository of target, cloning it if target is a
+// URL. The returned cleanup function removes the clone.
+func (b *Builder) resolveRepo(ctx context.Context, target string, fullHistory bool) (repoPath string, repoName string, cleanup func(), err error) {
+	if target == "" {
+		target = "."
+	}
+
+	if inputs.IsRemoteURL(target) {
+		repoPath, err = inputs.CloneRemote(ctx, target, fullHistory)
+		if err != nil {
+			return "", "", nil, err
+		}
+		b.logf("Cloned %v into %v\n", target, repoPath)
+		return repoPath, inputs.RemoteRepoName(target), func() { os.RemoveAll(repoPath) }, nil
+	}
+
+	repoPath, err = inputs.FindGitRoot(target)
+	if err != nil {
+		return "", "", nil, invalidError{fmt.Errorf("error finding repository: %w", err)}
+	}
+	b.logf("Found repo at %v\n", repoPath)
+	return repoPath, "", func() {}, nil
+}
+
+// withProjectConfig fills the unset options from the project config file of
+// the repository and the defaults, it returns the path of the file used
+func withProjectConfig(opts Options, repoPath string) (Options, string, error) {
+	var path string
+	if !opts.IgnoreProjectConfig {
+		project, projectPath, err := inputs.LoadProjectConfig(repoPath)
+		if err != nil {
+			return opts, "", err
+		}
+		path = projectPath
+
+		// Options given explicitly are kept even if they are zero
+		unset := func(setting string, zero bool) bool {
+			return zero && !opts.Explicit[setting]
+		}
+		if unset("ignore", opts.IgnorePatterns == nil) {
+			opts.IgnorePatterns = project.Ignore
+		}
+		if unset("summary", opts.SummaryPatterns == nil) {
+			opts.SummaryPatterns = project.Summary
+		}
+		if unset("extensions", opts.ExtraExtensions == nil) {
+			opts.ExtraExtensions = project.Extensions
+		}
+		if unset("format", opts.Format == "") {
+			opts.Format = project.Format
+		}
+		if unset("tokenizer", opts.Tokenizer == "") {
+			opts.Tokenizer = project.Tokenizer
+		}
+		if unset("max_tokens", opts.MaxTokens == 0) {
+			opts.MaxTokens = project.MaxTokens
+		}
+		if unset("order", opts.Order == "") {
+			opts.Order = project.Order
+		}
+		if unset("prompt", opts.Prompt == "") {
+			opts.Prompt = project.Prompt
+		}
+		if unset("tracked_only", !opts.TrackedOnly) {
+			opts.TrackedOnly = project.TrackedOnly
+		}
+	}
+
+	if opts.Format == "" {
+		opts.Format = "xml"
+	}
+	if opts.Tokenizer == "" {
+		opts.Tokenizer = "bpe"
+	}
+	if opts.Order == "" {
+		opts.Order = "path"
+	}
+	if opts.NumWorkers <= 0 {
+		opts.NumWorkers = runtime.NumCPU()
+	}
+	return opts, path, nil
+}
+
+// EffectiveOptions returns opts merged with the project config file of the
+// target repository and the defaults, as Build would use them, together
+// with the path of the project config file, if any
+func (b *Builder) EffectiveOptions() (Options, string, error) {
+	repoPath, _, cleanup, err := b.resolveRepo(context.Background(), b.opts.Target, false)
+	if err != nil {
+		return b.opts, "", err
+	}
+	defer cleanup()
+
+	return withProjectConfig(b.opts, repoPath)
+}
+
+// config resolves the repository and turns the options into the config of
+// the pipeline. The returned cleanup function removes a clone.
+func (b *Builder) config(ctx context.Context) (inputs.Config, func(), error) {
+	opts := b.opts
+	var config inputs.Config
+
+	if opts.Since != "" && opts.Diff != "" {
+		return config, nil, invalidError{fmt.Errorf("since and diff can not be combined")}
+	}
+	diffBase, diffHead, diffMergeBase := opts.Since, "HEAD", false
+	ref := opts.Ref
+	var err error
+	if opts.Diff != "" {
+		diffBase, diffHead, diffMergeBase, err = inputs.ParseDiffRange(opts.Diff)
+		if err != nil {
+			return config, nil, invalidError{err}
+		}
+		// File contents are taken from the head of the range
+		if ref == "" {
+			ref = diffHead
+		}
+	} else if ref != "" {
+		diffHead = ref
+	}
+
+	repoPath, repoName, cleanup, err := b.resolveRepo(ctx, opts.Target, ref != "" || diffBase != "")
+	if err != nil {
+		return config, nil, err
+	}
+	// Later errors remove the clone right away
+	fail := func(err error) (inputs.Config, func(), error) {
+		cleanup()
+		return config, nil, err
+	}
+
+	opts, _, err = withProjectConfig(opts, repoPath)
+	if err != nil {
+		return fail(err)
+	}
+
+	tokenizer, err := inputs.NewTokenizer(opts.Tokenizer)
+	if err != nil {
+		return fail(invalidError{err})
+	}
+
+	renderer, err := inputs.NewRenderer(opts.Format)
+	if err != nil {
+		return fail(invalidError{err})
+	}
+	if err := inputs.CheckOrder(opts.Order); err != nil {
+		return fail(invalidError{err})
+	}
+	revisions := []string{ref}
+	if diffBase != "" {
+		revisions = append(revisions, diffBase, diffHead)
+	}
+	if err := inputs.CheckRevisions(repoPath, revisions...); err != nil {
+		return fail(invalidError{err})
+	}
+
+	var redactor *inputs.Redactor
+	if !opts.NoRedact {
+		redactor, err = inputs.NewRedactor(opts.RedactRules)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	// Clones live in a new directory every time, their cache would never
+	// be used again
+	cacheDir := opts.CacheDir
+	if inputs.IsRemoteURL(opts.Target) {
+		cacheDir = ""
+	}
+
+	textExtensions := make(map[string]bool)
+	if opts.TextExtensions == nil {
+		textExtensions = inputs.DefaultTextExtensions()
+	} else {
+		for ext, ok := range opts.TextExtensions {
+			textExtensions[ext] = ok
+		}
+	}
+	for ext := range inputs.NormalizeExtensions(opts.ExtraExtensions) {
+		textExtensions[ext] = true
+	}
+
+	config = inputs.Config{
+		InputDir:          repoPath,
+		TextExtensions:    textExtensions,
+		BinaryExtensions:  inputs.DefaultBinaryExtensions(),
+		IncludeExtensions: inputs.NormalizeExtensions(opts.IncludeExtensions),
+		ExcludeExtensions: inputs.NormalizeExtensions(opts.ExcludeExtensions),
+		NumWorkers:        opts.NumWorkers,
+		RepoPath:          repoPath,
+		RepoName:          repoName,
+		Prompt:            opts.Prompt,
+		IgnorePatterns:    append(append([]string(nil), opts.IgnorePatterns...), opts.ExcludePaths...),
+		SummaryPatterns:   opts.SummaryPatterns,
+		Tokenizer:         tokenizer,
+		MaxTokens:         opts.MaxTokens,
+		Renderer:          renderer,
+		Order:             opts.Order,
+		DiffBase:          diffBase,
+		DiffHead:          diffHead,
+		DiffMergeBase:     diffMergeBase,
+		Ref:               ref,
+		Tree:              !opts.NoTree,
+		TrackedOnly:       opts.TrackedOnly,
+		Redactor:          redactor,
+		CacheDir:          cacheDir,
+		StatsCommits:      opts.StatsCommits,
+		ChurnCommits:      opts.ChurnCommits,
+	}
+	return config, cleanup, nil
+}
+
+// Build writes the synopsis to w
+func (b *Builder) Build(ctx context.Context, w io.Writer) (*Result, error) {
+	config, cleanup, err := b.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// The parts around the files are rendered first, their tokens count
+	// against the token budget
+	var header, closing bytes.Buffer
+	if err := inputs.InputHeader(config, &header); err != nil {
+		return nil, err
+	}
+	// The synopsis is still useful without statistics
+	statsErr := inputs.InputRepoStats(ctx, config, &header)
+	if err := inputs.InputContext(config, &closing); err != nil {
+		return nil, err
+	}
+	if err := inputs.InputFooter(config, &closing); err != nil {
+		return nil, err
+	}
+	config.ReservedTokens = inputs.CountTokens(ctx, config, header.String()+closing.String())
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := header.WriteTo(w); err != nil {
+		return nil, err
+	}
+
+	b.logf("Starting file concatenation with %d workers...\n", config.NumWorkers)
+	report, err := inputs.MergeFiles(ctx, config, w)
+	if err != nil {
+		return nil, err
+	}
+	if statsErr != nil {
+		report.Errors = append([]inputs.RunError{{Stage: "stats", Message: statsErr.Error()}}, report.Errors...)
+	}
+
+	if _, err := closing.WriteTo(w); err != nil {
+		return nil, err
+	}
+
+	return newResult(config, report), nil
+}
+
+// BuildChunks writes the synopsis split into parts of at most ChunkSize
+// bytes or tokens. open is called for each part with its number, counting
+// from one, and the number of parts, the writer is closed once the part is
+// written. Nothing is written if a file does not fit in a part of its own.
+func (b *Builder) BuildChunks(ctx context.Context, open func(part int, total int) (io.WriteCloser, error)) (*Result, error) {
+	if b.opts.ChunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive")
+	}
+	config, cleanup, err := b.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	b.logf("Starting file concatenation with %d workers...\n", config.NumWorkers)
+	limit := inputs.ChunkLimit{Size: b.opts.ChunkSize, Tokens: b.opts.ChunkTokens}
+	report, err := inputs.MergeChunks(ctx, config, limit, open)
+	if err != nil {
+		return nil, err
+	}
+	return newResult(config, report), nil
+}
+
+// File returns the block of a single file of the synopsis, relPath is
+// relative to the repository root
+func (b *Builder) File(ctx context.Context, relPath string) (string, error) {
+	config, cleanup, err := b.config(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	return inputs.RenderFile(ctx, config, relPath)
+}
+
+// Tree lists every file of the repository that is not ignored, with its
+// status in the synopsis
+func (b *Builder) Tree(ctx context.Context) ([]TreeEntry, error) {
+	config, cleanup, err := b.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return inputs.ListTree(ctx, config)
+}
+
+// Search finds the lines matching pattern in the files of the synopsis, at
+// most limit of them or all for 0
+func (b *Builder) Search(ctx context.Context, pattern *regexp.Regexp, limit int) ([]SearchMatch, error) {
+	config, cleanup, err := b.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return inputs.Search(ctx, config, pattern, limit)
+}
+
+// RepoStats writes the repository statistics of the synopsis to w as a
+// document of its own: the recent commits, contributors, languages, most
+// changed files, tags and branches. In json the document is {"stats":...}.
+func (b *Builder) RepoStats(ctx context.Context, w io.Writer) error {
+	config, cleanup, err := b.config(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return inputs.RenderRepoStats(ctx, config, w)
+}
+
+func newResult(config inputs.Config, report *inputs.Report) *Result {
+	return &Result{
+		RepoPath:   config.RepoPath,
+		Tokens:     report.Tokens,
+		Binary:     report.Binary,
+		Redactions: report.Redactions,
+		Errors:     report.Errors,
+		Cache:      report.Cache,
+	}
+}
@@ -0,0 +1,59 @@
+package synopsis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"reposyn/internal/testrepo"
+)
+
+func TestBuildToWriter(t *testing.T) {
+	dir := testrepo.New(t, map[string]string{
+		"README.md":   "# Library\n",
+		"lib/code.go": "package lib\n",
+	})
+
+	var buffer bytes.Buffer
+	result, err := NewBuilder(Options{Target: dir, Format: "markdown"}).Build(context.Background(), &buffer)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if result.RepoPath != dir {
+		t.Errorf("Expected repo path %v, got %v", dir, result.RepoPath)
+	}
+	if len(result.Tokens.Files) != 2 {
+		t.Errorf("Expected two files in the token report, got %d", len(result.Tokens.Files))
+	}
+	if !strings.Contains(buffer.String(), "### lib/code.go\n\n```go\npackage lib\n```") {
+		t.Errorf("Synopsis is missing lib/code.go:\n%s", buffer.String())
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	dir := testrepo.New(t, map[string]string{"README.md": "# Library\n"})
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		opts Options
+		want error
+	}{
+		{"cancelled", cancelled, Options{Target: dir}, context.Canceled},
+		{"outside of a repository", context.Background(), Options{Target: t.TempDir()}, ErrInvalidOptions},
+		{"unknown format", context.Background(), Options{Target: dir, Format: "yaml"}, ErrInvalidOptions},
+		{"unknown order", context.Background(), Options{Target: dir, Order: "random"}, ErrInvalidOptions},
+		{"unknown ref", context.Background(), Options{Target: dir, Ref: "missing"}, ErrInvalidOptions},
+	}
+	for _, test := range tests {
+		_, err := NewBuilder(test.opts).Build(test.ctx, &bytes.Buffer{})
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
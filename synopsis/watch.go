@@ -0,0 +1,97 @@
+package synopsis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"reposyn/internal/inputs"
+)
+
+// WatchOptions configures Builder.Watch
+type WatchOptions struct {
+	// Interval is the time between two scans of the repository, 0 for one
+	// second
+	Interval time.Duration
+	// Debounce is how long the files must stay unchanged after an edit
+	// before the synopsis is rebuilt, so a burst of edits rebuilds it once
+	Debounce time.Duration
+}
+
+// Watch scans the working tree of the repository, leaving out the files
+// ignored like in a build, and calls rebuild with the changed files once
+// they settle. Errors of rebuild are logged and watching goes on. Watch
+// returns when ctx is done.
+func (b *Builder) Watch(ctx context.Context, opts WatchOptions, rebuild func(ctx context.Context, changed []string) error) error {
+	if inputs.IsRemoteURL(b.opts.Target) {
+		return fmt.Errorf("only local repositories can be watched")
+	}
+	config, cleanup, err := b.config(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	snapshotter, err := inputs.NewSnapshotter(config)
+	if err != nil {
+		return err
+	}
+	previous, err := snapshotter.Snapshot()
+	if err != nil {
+		return err
+	}
+	b.logf("Watching %v for changes...\n", config.RepoPath)
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	var changed []string
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := snapshotter.Snapshot()
+		if err != nil {
+			b.logf("Error scanning the repository: %v\n", err)
+			continue
+		}
+		if files := inputs.ChangedFiles(previous, current); len(files) > 0 {
+			changed = mergeChanged(changed, files)
+			lastChange = time.Now()
+		}
+		previous = current
+
+		if len(changed) == 0 || time.Since(lastChange) < opts.Debounce {
+			continue
+		}
+		b.logf("Changed: %s\n", strings.Join(changed, ", "))
+		if err := rebuild(ctx, changed); err != nil {
+			b.logf("Error rebuilding the synopsis: %v\n", err)
+		}
+		changed = nil
+	}
+}
+
+// mergeChanged adds the files not yet listed in changed
+func mergeChanged(changed []string, files []string) []string {
+	for _, file := range files {
+		found := false
+		for _, c := range changed {
+			if c == file {
+				found = true
+				break
+			}
+		}
+		if !found {
+			changed = append(changed, file)
+		}
+	}
+	return changed
+}